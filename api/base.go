@@ -1,7 +1,11 @@
 package api
 
 import (
+	"errors"
+	"net"
+
 	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
 	"github.com/YouDad/blockchain/utils"
 
 	"github.com/astaxie/beego"
@@ -9,6 +13,16 @@ import (
 
 type BaseController struct {
 	beego.Controller
+	peer *network.PeerRequest // 节点间协议的请求，HTTP请求时为nil
+}
+
+// 节点间协议和HTTP共用控制器，路由时设置请求
+type PeerController interface {
+	SetPeerRequest(req *network.PeerRequest)
+}
+
+func (c *BaseController) SetPeerRequest(req *network.PeerRequest) {
+	c.peer = req
 }
 
 type SimpleJSONResult struct {
@@ -17,18 +31,61 @@ type SimpleJSONResult struct {
 }
 
 func (c *BaseController) ParseParameter(data interface{}) {
-	log.Debugln(log.Funcname(1), c.GetString("address"))
-	if data != nil {
-		err := utils.Decode(c.Ctx.Input.RequestBody, data)
+	log.Debugln(log.Funcname(1), c.PeerID(), c.GetString("address"))
+	if network.IsBanned(c.PeerID()) {
+		c.ReturnErr(network.ErrPeerBanned)
+	}
+	if data == nil {
+		return
+	}
+
+	var err error
+	if c.peer != nil {
+		err = c.peer.Decode(data)
+	} else {
+		err = utils.Decode(c.Ctx.Input.RequestBody, data)
 		if err != nil {
-			log.Warnln(string(c.Ctx.Input.RequestBody))
-			log.Warnln(err)
-			c.ReturnErr(err)
+			log.Warnln(log.Funcname(1), err)
 		}
 	}
+	if err != nil {
+		network.Misbehave(c.PeerID(), network.ScoreMalformed, err.Error())
+		c.ReturnErr(err)
+	}
+}
+
+// 请求是否来自本机，用TCP连接的地址判断，不看可以伪造的X-Forwarded-For
+func (c *BaseController) isLocal() bool {
+	if c.peer != nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(c.Ctx.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// HTTP请求只接受本机的，其他节点只能请求net/Peer升级到节点间协议，
+// 节点间协议的请求不经过这里
+func (c *BaseController) Prepare() {
+	if c.Ctx.Input.URL() != "/v1/net/Peer" && !c.isLocal() {
+		c.ReturnErr(errors.New("Only local request is allowed"))
+	}
+}
+
+// 钱包等接口只允许本机调用
+func (c *BaseController) RequireLocal() {
+	if !c.isLocal() {
+		c.ReturnErr(errors.New("Only local request is allowed"))
+	}
 }
 
 func (c *BaseController) ReturnJson(data SimpleJSONResult) {
+	if c.peer != nil {
+		c.peer.Return(data.Message, data.Data)
+	}
 	c.Data["json"] = data
 	c.ServeJSON()
 	c.StopRun()
@@ -45,5 +102,31 @@ func (c *BaseController) Return(data interface{}) {
 }
 
 func (c *BaseController) Param(key string) string {
+	if c.peer != nil {
+		return ""
+	}
 	return c.Ctx.Input.Param(key)
 }
+
+// 调用者的地址，节点间协议中是握手时验证过的地址，
+// HTTP请求中的地址不能验证，只相信本机的请求
+func (c *BaseController) GetString(key string, def ...string) string {
+	if c.peer != nil {
+		if key == "address" {
+			return c.peer.Address
+		}
+		return ""
+	}
+	if key == "address" && !c.isLocal() {
+		return ""
+	}
+	return c.Controller.GetString(key, def...)
+}
+
+// 调用者的节点ID，HTTP请求时为空
+func (c *BaseController) PeerID() string {
+	if c.peer != nil {
+		return c.peer.ID
+	}
+	return ""
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -28,11 +29,11 @@ var (
 	ErrNull = errors.New("null")
 )
 
-func GetGenesis(group int) (*types.Block, error) {
+func GetGenesis(ctx context.Context, group int) (*types.Block, error) {
 	args := GetGenesisArgs{group}
 	var reply GetGenesisReply
 
-	err, _ := network.CallInnerGroup("db/GetGenesis", &args, &reply)
+	err, _ := network.CallInnerGroup(ctx, "db/GetGenesis", &args, &reply)
 	if err != nil {
 		return nil, err
 	}
@@ -62,14 +63,14 @@ type GetBalanceArgs = struct {
 	Address string
 }
 type GetBalanceReply = struct {
-	Balance int64
+	Balance types.Amount
 }
 
-func GetBalance(address string) (int64, error) {
+func GetBalance(ctx context.Context, address string) (types.Amount, error) {
 	args := GetBalanceArgs{address}
 	var reply GetBalanceReply
 
-	err := network.CallSelf("db/GetBalance", &args, &reply)
+	err := network.CallSelf(ctx, "db/GetBalance", &args, &reply)
 	return reply.Balance, err
 }
 
@@ -82,15 +83,131 @@ func (c *DBController) GetBalance() {
 	if !wallet.ValidateAddress(args.Address) {
 		c.ReturnJson(SimpleJSONResult{"Address is not valid", nil})
 	}
-	set := core.GetUTXOSet(global.GetGroup())
+	group := global.GetGroupByAddress(args.Address)
+	if !global.DatabaseExists(group) {
+		c.ReturnErr(fmt.Errorf("Blockchain[%d] is not here", group))
+	}
+	set := core.GetUTXOSet(group)
 
 	reply.Balance = 0
-	pubKeyHash := utils.Base58Decode([]byte(args.Address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	utxos := set.FindUTXOByHash(pubKeyHash)
+	utxos := set.FindUTXOByHash(wallet.AddressToPubKeyHash(args.Address))
 
 	for _, utxo := range utxos {
-		reply.Balance += utxo.Value
+		balance, err := reply.Balance.Add(utxo.Value)
+		c.ReturnErr(err)
+		reply.Balance = balance
+	}
+
+	c.Return(reply)
+}
+
+type GetBalancesArgs = struct {
+	Group     int
+	Addresses []string
+}
+type GetBalancesReply = struct {
+	Balances []types.Amount
+}
+
+// 查询同一组内多个地址的余额，先问本节点，本节点没有该组时问负责该组的节点
+func GetBalances(ctx context.Context, group int, addresses []string) ([]types.Amount, error) {
+	args := GetBalancesArgs{group, addresses}
+	var reply GetBalancesReply
+
+	err := network.CallSelf(ctx, "db/GetBalances", &args, &reply)
+	if err != nil {
+		err, _ = network.CallGroup(ctx, group, "db/GetBalances", &args, &reply)
+	}
+	return reply.Balances, err
+}
+
+// @router /GetBalances [post]
+func (c *DBController) GetBalances() {
+	var args GetBalancesArgs
+	var reply GetBalancesReply
+	c.ParseParameter(&args)
+
+	if !global.DatabaseExists(args.Group) {
+		c.ReturnErr(fmt.Errorf("Blockchain[%d] is not here", args.Group))
+	}
+
+	set := core.GetUTXOSet(args.Group)
+	reply.Balances = make([]types.Amount, len(args.Addresses))
+	for i, address := range args.Addresses {
+		if !wallet.ValidateAddress(address) || global.GetGroupByAddress(address) != args.Group {
+			c.ReturnErr(fmt.Errorf("Address %s is not valid in group %d", address, args.Group))
+		}
+
+		for _, utxo := range set.FindUTXOByHash(wallet.AddressToPubKeyHash(address)) {
+			balance, err := reply.Balances[i].Add(utxo.Value)
+			c.ReturnErr(err)
+			reply.Balances[i] = balance
+		}
+	}
+
+	c.Return(reply)
+}
+
+type FindUsedAddressesArgs = struct {
+	Group     int
+	Addresses []string
+}
+type FindUsedAddressesReply = struct {
+	Used []bool
+}
+
+// 一次查询的地址数上限
+const maxFindUsedAddresses = 1000
+
+// 查询同一组内的地址是否在链上收到过转账，用于HD钱包的gap limit扫描，
+// 地址多时分几次查询
+func FindUsedAddresses(ctx context.Context, group int, addresses []string) ([]bool, error) {
+	used := make([]bool, 0, len(addresses))
+	for len(addresses) > 0 {
+		n := len(addresses)
+		if n > maxFindUsedAddresses {
+			n = maxFindUsedAddresses
+		}
+		args := FindUsedAddressesArgs{group, addresses[:n]}
+		var reply FindUsedAddressesReply
+
+		err := network.CallSelf(ctx, "db/FindUsedAddresses", &args, &reply)
+		if err != nil {
+			err, _ = network.CallGroup(ctx, group, "db/FindUsedAddresses", &args, &reply)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(reply.Used) != n {
+			return nil, fmt.Errorf("FindUsedAddresses replied %d results for %d addresses",
+				len(reply.Used), n)
+		}
+		used = append(used, reply.Used...)
+		addresses = addresses[n:]
+	}
+	return used, nil
+}
+
+// @router /FindUsedAddresses [post]
+func (c *DBController) FindUsedAddresses() {
+	var args FindUsedAddressesArgs
+	var reply FindUsedAddressesReply
+	c.ParseParameter(&args)
+
+	if len(args.Addresses) > maxFindUsedAddresses {
+		c.ReturnErr(fmt.Errorf("Too many addresses, at most %d", maxFindUsedAddresses))
+	}
+	if !global.DatabaseExists(args.Group) {
+		c.ReturnErr(fmt.Errorf("Blockchain[%d] is not here", args.Group))
+	}
+
+	used := core.GetBlockchain(args.Group).FindUsedPubKeyHashes()
+	reply.Used = make([]bool, len(args.Addresses))
+	for i, address := range args.Addresses {
+		if !wallet.ValidateAddress(address) || global.GetGroupByAddress(address) != args.Group {
+			c.ReturnErr(fmt.Errorf("Address %s is not valid in group %d", address, args.Group))
+		}
+		reply.Used[i] = used[wallet.AddressToPubKeyHash(address).String()]
 	}
 
 	c.Return(reply)
@@ -106,19 +223,19 @@ type GetBlocksReply = struct {
 	Blocks []*types.Block
 }
 
-func CallbackGetBlocks(group int, start, end int32, hash types.HashValue, address string) ([]*types.Block, error) {
+func CallbackGetBlocks(ctx context.Context, group int, start, end int32, hash types.HashValue, address string) ([]*types.Block, error) {
 	args := GetBlocksArgs{group, start, end, hash}
 	var reply GetBlocksReply
 
-	err := network.CallBack(address, "db/GetBlocks", &args, &reply)
+	err := network.CallBack(ctx, address, "db/GetBlocks", &args, &reply)
 
 	return reply.Blocks, err
 }
 
-func GetBlocks(group int, start, end int32, hash types.HashValue) []*types.Block {
+func GetBlocks(ctx context.Context, group int, start, end int32, hash types.HashValue) []*types.Block {
 	args := GetBlocksArgs{group, start, end, hash}
 	var reply GetBlocksReply
-	err, _ := network.CallInnerGroup("db/GetBlocks", &args, &reply)
+	err, _ := network.CallInnerGroup(ctx, "db/GetBlocks", &args, &reply)
 	log.Warn(err)
 
 	return reply.Blocks
@@ -133,6 +250,9 @@ func (c *DBController) GetBlocks() {
 	if args.From == 0 {
 		c.ReturnErr(errors.New("Height 0 use GetGenesis"))
 	}
+	if args.To-args.From >= syncWindow {
+		c.ReturnErr(fmt.Errorf("Too many blocks, at most %d", syncWindow))
+	}
 
 	bc := core.GetBlockchain(args.Group)
 	block := bc.GetBlockByHeight(args.From)
@@ -162,12 +282,53 @@ type GossipTxnArgs = struct {
 	Group int
 }
 
+// 只广播交易哈希，对方没有时通过GetData取得交易
 func GossipTxn(group int, txn types.Transaction, exceptedAddress string) {
-	go network.GossipCallInnerGroup("db/GossipTxn", &GossipTxnArgs{txn, group}, nil, exceptedAddress)
+	items := []network.InvItem{{Type: network.InvTxn, Group: group, Hash: txn.Hash()}}
+	go network.GossipInv(network.Context(), "db/Inv", items, exceptedAddress)
 }
 
 var mutexGossipTxn sync.Mutex
 
+var (
+	ErrTxnKnown       = errors.New("transaction is already known")
+	ErrTxnDoubleSpend = errors.New("transaction double spends")
+)
+
+// 校验交易并加入group组的交易池
+func addTxn(group int, txn types.Transaction) error {
+	mutexGossipTxn.Lock()
+	defer mutexGossipTxn.Unlock()
+	if _, err := mempool.GetTxn(group, txn.Hash()); err == nil {
+		return ErrTxnKnown
+	}
+
+	bc := core.GetBlockchain(group)
+
+	if _, err := bc.FindTxn(txn.Hash()); err == nil {
+		return ErrTxnKnown
+	}
+
+	prevOuts, err := bc.FindPrevOuts(txn)
+	if err != nil {
+		return err
+	}
+	if err := core.RelayPolicy.CheckTransaction(txn, prevOuts); err != nil {
+		return err
+	}
+
+	if err := bc.VerifyTransaction(txn); err != nil {
+		return err
+	}
+
+	if !core.GetUTXOSet(group).UTXOMemVerifyTransaction(txn) {
+		return ErrTxnDoubleSpend
+	}
+
+	mempool.AddTxn(group, txn)
+	return nil
+}
+
 // @router /GossipTxn [post]
 func (c *DBController) GossipTxn() {
 	var args GossipTxnArgs
@@ -176,32 +337,57 @@ func (c *DBController) GossipTxn() {
 		c.Return(nil)
 	}
 
-	mutexGossipTxn.Lock()
-	defer mutexGossipTxn.Unlock()
-	if _, err := mempool.GetTxn(args.Group, args.Txn.Hash()); err == nil {
-		c.Return(nil)
+	receiveTxn(args.Group, args.Txn, c.GetString("address"))
+	c.Return(nil)
+}
+
+// 收到address发来的交易，加入交易池后继续广播
+func receiveTxn(group int, txn types.Transaction, address string) {
+	err := addTxn(group, txn)
+	if err == nil {
+		log.Infof("AddTxn %s\n", txn.Hash())
+		GossipTxn(group, txn, address)
+	} else if err != ErrTxnKnown {
+		log.Infof("[FAIL]AddTxn because %s, hash: %s\n", err.Error(), txn.Hash())
 	}
+}
 
-	bc := core.GetBlockchain(args.Group)
+type SendRawTxnArgs = struct {
+	Raw string
+}
+type SendRawTxnReply = struct {
+	Hash types.HashValue
+}
 
-	if _, err := bc.FindTxn(args.Txn.Hash()); err == nil {
-		log.Infof("[FAIL]AddTxn find true %s", args.Txn.Hash())
-		c.Return(nil)
-	}
+func SendRawTxn(ctx context.Context, raw string) (types.HashValue, error) {
+	args := SendRawTxnArgs{raw}
+	var reply SendRawTxnReply
 
-	err := bc.VerifyTransaction(args.Txn)
-	if err != nil {
-		log.Infof("[FAIL]AddTxn Verify false, because %s, hash: %s\n", err.Error(), args.Txn.Hash())
-		c.Return(nil)
+	err := network.CallSelf(ctx, "db/SendRawTxn", &args, &reply)
+	return reply.Hash, err
+}
+
+// @router /SendRawTxn [post]
+func (c *DBController) SendRawTxn() {
+	var args SendRawTxnArgs
+	c.ParseParameter(&args)
+
+	raw, err := types.DecodeRawTxn(args.Raw)
+	c.ReturnErr(err)
+	if len(raw.Txn.Vin) == 0 || !raw.IsComplete() {
+		c.ReturnErr(errors.New("Raw transaction is not completely signed"))
 	}
 
-	if core.GetUTXOSet(args.Group).UTXOMemVerifyTransaction(args.Txn) {
-		mempool.AddTxn(args.Group, args.Txn)
-		log.Infof("AddTxn %s\n", args.Txn.Hash())
-		GossipTxn(args.Group, args.Txn, c.Param(":address"))
+	group := global.GetGroupByPubKeyHash(raw.Txn.Vin[0].PubKey.Hash())
+	if !utils.InGroup(group, global.GetGroup(), global.GroupNum, global.MaxGroupNum) {
+		c.ReturnErr(errors.New(fmt.Sprintf("Group %d is not served by this node", group)))
 	}
 
-	c.Return(nil)
+	c.ReturnErr(addTxn(group, raw.Txn))
+	c.ReturnErr(network.GetKnownNodes(network.Context()))
+	log.Infof("AddTxn Raw %s\n", raw.Txn.Hash())
+	GossipTxn(group, raw.Txn, global.SelfAddress())
+	c.Return(SendRawTxnReply{raw.Txn.Hash()})
 }
 
 type GossipRelayTxnArgs = struct {
@@ -214,7 +400,7 @@ type GossipRelayTxnArgs = struct {
 
 func GossipRelayTxn(fromGroup int, toGroup int, height int32,
 	relayMerklePath []types.MerklePath, txn *types.Transaction, exceptedAddress string) {
-	go network.GossipCallSpecialGroup("db/GossipRelayTxn", &GossipRelayTxnArgs{
+	go network.GossipCallSpecialGroup(network.Context(), "db/GossipRelayTxn", &GossipRelayTxnArgs{
 		fromGroup, toGroup, height, relayMerklePath, *txn}, nil, toGroup, exceptedAddress)
 }
 
@@ -231,6 +417,13 @@ func (c *DBController) GossipRelayTxn() {
 		c.Return(nil)
 	}
 
+	// 引用的输出在其他组，输入由后面的默克尔路径保证
+	prevOuts := make([]*types.TxnOutput, len(args.Txn.Vin))
+	if err := core.RelayPolicy.CheckTransaction(args.Txn, prevOuts); err != nil {
+		log.Infof("[FAIL]AddTxn Relay policy reject, because %s, hash: %s\n", err.Error(), args.Txn.Hash())
+		c.Return(nil)
+	}
+
 	block := core.GetBlockhead(args.FromGroup).GetBlockheadByHeight(args.Height)
 	if block == nil {
 		log.Infoln("[FAIL]AddTxn Relay have no blockhead")
@@ -239,6 +432,8 @@ func (c *DBController) GossipRelayTxn() {
 
 	if !args.Txn.RelayVerify(block.MerkleRoot, args.RelayMerklePath) {
 		log.Infoln("[FAIL]AddTxn Relay verify false")
+		network.Misbehave(c.PeerID(), network.ScoreInvalidRelay,
+			fmt.Sprintf("invalid relay proof for %s", args.Txn.Hash()))
 		c.Return(nil)
 	}
 
@@ -255,16 +450,18 @@ func (c *DBController) GossipRelayTxn() {
 type GossipBlockArgs = types.Block
 
 func CallbackGossipBlock(block *types.Block, address string) {
-	go network.CallBack(address, "db/GossipBlock", block, nil)
+	go network.CallBack(network.Context(), address, "db/GossipBlock", block, nil)
 }
 
+// 只广播区块哈希，对方没有时通过GetData取得区块
 func GossipBlock(block *types.Block, exceptedAddress string) {
-	go network.GossipCallInnerGroup("db/GossipBlock", block, nil, exceptedAddress)
+	items := []network.InvItem{{Type: network.InvBlock, Group: block.Group, Hash: block.Hash()}}
+	go network.GossipInv(network.Context(), "db/Inv", items, exceptedAddress)
 }
 
 func CallSelfBlock(block types.Block) {
-	network.CallSelf("db/GossipBlock", &block, nil)
-	GossipBlockHead(block, "127.0.0.1:"+global.Port)
+	network.CallSelf(network.Context(), "db/GossipBlock", &block, nil)
+	GossipBlockHead(block, global.SelfAddress())
 }
 
 var mutexGossipBlock sync.Mutex
@@ -277,11 +474,23 @@ func (c *DBController) GossipBlock() {
 		c.Return(nil)
 	}
 
+	receiveBlock(&args, c.GetString("address"))
+	c.Return(nil)
+}
+
+// 收到address发来的区块，是后继区块时加入区块链并继续广播
+func receiveBlock(block *types.Block, address string) {
+	if !block.Verify() {
+		network.Misbehave(network.PeerID(address), network.ScoreInvalidBlock,
+			fmt.Sprintf("block %s fails proof of work", block.Hash()))
+		return
+	}
+
 	mutexGossipBlock.Lock()
 	defer mutexGossipBlock.Unlock()
 	log.Debugln("GossipBlock", "{{{{{{{{")
-	bc := core.GetBlockchain(args.Group)
-	set := core.GetUTXOSet(args.Group)
+	bc := core.GetBlockchain(block.Group)
+	set := core.GetUTXOSet(block.Group)
 	lastest := bc.GetLastest()
 
 	var lastestHeight int32 = -1
@@ -290,45 +499,170 @@ func (c *DBController) GossipBlock() {
 	}
 
 	log.Debugf("GossipBlock[%d] get=%d, lastest=%d\n",
-		args.Group, args.Height, lastestHeight)
+		block.Group, block.Height, lastestHeight)
 
 	// 认为对方的区块不够新，反向广播
-	if args.Height < lastestHeight {
-		CallbackGossipBlock(lastest, c.GetString("address"))
+	if block.Height < lastestHeight {
+		CallbackGossipBlock(lastest, address)
 	}
 
 	// 从高度上来说可能是后继区块
-	if args.Height == lastestHeight+1 {
+	if block.Height == lastestHeight+1 {
 		// 满足哈希链
-		if args.PrevHash.Equal(lastest.Hash()) {
+		if block.PrevHash.Equal(lastest.Hash()) {
+			if err := bc.VerifyBlockTxns(block); err != nil {
+				log.Infoln("[FAIL]AddBlock", err)
+				network.Misbehave(network.PeerID(address), network.ScoreInvalidBlock, err.Error())
+				return
+			}
+
 			global.SyncLock()
-			bc.AddBlock(&args)
-			set.Update(&args)
+			bc.AddBlock(block)
+			set.Update(block)
 			global.SyncUnlock()
-			GossipBlock(&args, c.Param(":address"))
+			GossipBlock(block, address)
 			lastestHeight += 1
 		}
 	}
 
 	// 认为我们和主链差一些区块
-	if args.Height > lastestHeight {
+	if block.Height > lastestHeight {
 		if lastestHeight == -1 {
-			Sync(args.Group)
+			Sync(network.Context(), block.Group)
 		} else {
-			SyncBlocks(args.Group, args.Height, c.GetString("address"))
+			SyncBlocks(network.Context(), block.Group, block.Height, address)
 		}
 	}
 
 	log.Debugln("GossipBlock", "}}}}}}}}")
+}
+
+type InvArgs = []network.InvItem
+
+// @router /Inv [post]
+func (c *DBController) Inv() {
+	var args InvArgs
+	c.ParseParameter(&args)
+	c.checkInvItems("Inv", args)
+	address := c.GetString("address")
+	network.MarkKnown(address, args)
+
+	var items []network.InvItem
+	for _, item := range args {
+		if utils.InGroup(item.Group, global.GetGroup(), global.GroupNum, global.MaxGroupNum) && !hasInv(item) {
+			items = append(items, item)
+		}
+	}
+
+	// 不在处理广播时等待对方回复
+	items = network.RequestInv(items)
+	if len(items) != 0 {
+		go getData(address, items)
+	}
 	c.Return(nil)
 }
 
+// 本节点是否已经有这个对象
+func hasInv(item network.InvItem) bool {
+	switch item.Type {
+	case network.InvTxn:
+		if _, err := mempool.GetTxn(item.Group, item.Hash); err == nil {
+			return true
+		}
+		_, err := core.GetBlockchain(item.Group).FindTxn(item.Hash)
+		return err == nil
+	case network.InvBlock:
+		return core.GetBlockchain(item.Group).GetBlockByHash(item.Hash) != nil
+	}
+	return true
+}
+
+type GetDataArgs = []network.InvItem
+type GetDataReply = struct {
+	Txns   []GossipTxnArgs
+	Blocks []*types.Block
+}
+
+// 向address取得广播的对象并处理，只接受请求过的对象
+func getData(address string, items []network.InvItem) {
+	defer network.DoneInv(items)
+	var reply GetDataReply
+	err := network.CallBack(network.Context(), address, "db/GetData", &items, &reply)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+
+	requested := make(map[string]bool)
+	for _, item := range items {
+		requested[item.Key()] = true
+	}
+	take := func(kind byte, group int, hash types.HashValue) bool {
+		key := network.InvItem{Type: kind, Group: group, Hash: hash}.Key()
+		if !requested[key] {
+			network.Misbehave(network.PeerID(address), network.ScoreMalformed,
+				fmt.Sprintf("GetData replied unrequested %s", hash))
+			return false
+		}
+		delete(requested, key)
+		return true
+	}
+
+	for _, args := range reply.Txns {
+		if take(network.InvTxn, args.Group, args.Txn.Hash()) {
+			receiveTxn(args.Group, args.Txn, address)
+		}
+	}
+	for _, block := range reply.Blocks {
+		if block != nil && take(network.InvBlock, block.Group, block.Hash()) {
+			receiveBlock(block, address)
+		}
+	}
+}
+
+// 对象个数超过上限的请求是畸形的
+func (c *DBController) checkInvItems(method string, items []network.InvItem) {
+	if len(items) > network.MaxInvItems {
+		network.Misbehave(c.PeerID(), network.ScoreMalformed,
+			fmt.Sprintf("%s has %d items", method, len(items)))
+		c.ReturnErr(fmt.Errorf("Too many items, at most %d", network.MaxInvItems))
+	}
+}
+
+// @router /GetData [post]
+func (c *DBController) GetData() {
+	var args GetDataArgs
+	var reply GetDataReply
+	c.ParseParameter(&args)
+	c.checkInvItems("GetData", args)
+	network.MarkKnown(c.GetString("address"), args)
+
+	for _, item := range args {
+		if !utils.InGroup(item.Group, global.GetGroup(), global.GroupNum, global.MaxGroupNum) {
+			continue
+		}
+		switch item.Type {
+		case network.InvTxn:
+			txn, err := mempool.GetTxn(item.Group, item.Hash)
+			if err == nil {
+				reply.Txns = append(reply.Txns, GossipTxnArgs{*txn, item.Group})
+			}
+		case network.InvBlock:
+			block := core.GetBlockchain(item.Group).GetBlockByHash(item.Hash)
+			if block != nil {
+				reply.Blocks = append(reply.Blocks, block)
+			}
+		}
+	}
+	c.Return(reply)
+}
+
 type GossipBlockHeadArgs = types.Block
 
 func GossipBlockHead(block types.Block, exceptedAddress string) {
 	go func() {
 		block.Txns = nil
-		network.GossipCallInterGroup("db/GossipBlockHead", &block, nil, exceptedAddress)
+		network.GossipCallInterGroup(network.Context(), "db/GossipBlockHead", &block, nil, exceptedAddress)
 	}()
 }
 
@@ -351,6 +685,8 @@ func (c *DBController) GossipBlockHead() {
 		}
 	} else {
 		log.Warnln("AddBlockhead Verify failed")
+		network.Misbehave(c.PeerID(), network.ScoreInvalidBlock,
+			fmt.Sprintf("block head %s fails proof of work", args.Hash()))
 	}
 
 	c.Return(nil)
@@ -362,20 +698,20 @@ type GetHashArgs struct {
 }
 type GetHashReply struct{ Hash types.HashValue }
 
-func CallbackGetHash(group int, height int32, address string) (types.HashValue, error) {
+func CallbackGetHash(ctx context.Context, group int, height int32, address string) (types.HashValue, error) {
 	args := GetHashArgs{group, height}
 	var reply GetHashReply
 
-	err := network.CallBack(address, "db/GetHash", &args, &reply)
+	err := network.CallBack(ctx, address, "db/GetHash", &args, &reply)
 
 	return reply.Hash, err
 }
 
-func GetHash(group int, height int32) (types.HashValue, error) {
+func GetHash(ctx context.Context, group int, height int32) (types.HashValue, error) {
 	args := GetHashArgs{group, height}
 	var reply GetHashReply
 
-	err, _ := network.CallInnerGroup("db/GetHash", &args, &reply)
+	err, _ := network.CallInnerGroup(ctx, "db/GetHash", &args, &reply)
 
 	return reply.Hash, err
 }
@@ -396,3 +732,59 @@ func (c *DBController) GetHash() {
 
 	c.Return(reply)
 }
+
+type GetHeadersArgs struct {
+	Group   int
+	Locator []types.HashValue
+}
+type GetHeadersReply struct {
+	Headers []*types.Block
+}
+
+// 一次最多返回的区块头个数
+const maxHeaders = 2000
+
+func CallbackGetHeaders(ctx context.Context, group int, locator []types.HashValue, address string) ([]*types.Block, error) {
+	args := GetHeadersArgs{group, locator}
+	var reply GetHeadersReply
+
+	err := network.CallBack(ctx, address, "db/GetHeaders", &args, &reply)
+
+	return reply.Headers, err
+}
+
+// 返回locator中第一个在主链上的区块之后的区块头
+// @router /GetHeaders [post]
+func (c *DBController) GetHeaders() {
+	var args GetHeadersArgs
+	var reply GetHeadersReply
+	c.ParseParameter(&args)
+	if !utils.InGroup(args.Group, global.GetGroup(), global.GroupNum, global.MaxGroupNum) {
+		c.ReturnErr(errors.New(fmt.Sprintf("Group %d is not served by this node", args.Group)))
+	}
+
+	bc := core.GetBlockchain(args.Group)
+	var start int32
+	for _, hash := range args.Locator {
+		block := bc.GetBlockByHash(hash)
+		if block == nil {
+			continue
+		}
+		main := bc.GetBlockByHeight(block.Height)
+		if main != nil && main.Hash().Equal(hash) {
+			start = block.Height + 1
+			break
+		}
+	}
+
+	lastestHeight := bc.GetHeight()
+	for height := start; height <= lastestHeight && len(reply.Headers) < maxHeaders; height++ {
+		block := bc.GetBlockByHeight(height)
+		if block == nil {
+			break
+		}
+		block.Txns = nil
+		reply.Headers = append(reply.Headers, block)
+	}
+	c.Return(reply)
+}
@@ -1,6 +1,10 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"sort"
+
 	"github.com/YouDad/blockchain/network"
 )
 
@@ -9,6 +13,11 @@ type NetController struct {
 	net network.NET
 }
 
+// 检查本节点的服务是否在运行
+func HeartBeat(ctx context.Context) error {
+	return network.CallSelf(ctx, "net/HeartBeat", nil, nil)
+}
+
 func (c *NetController) HeartBeat() {
 	c.ParseParameter(nil)
 
@@ -20,7 +29,83 @@ func (c *NetController) GetKnownNodes() {
 	var args network.GetKnownNodesArgs
 	var reply network.GetKnownNodesReply
 	c.ParseParameter(&args)
+	// 节点间协议中用握手时确定的地址，它已经换成了对方连接的IP
+	if c.peer != nil && args.Address != "" {
+		args.Address = c.peer.Address
+	}
 
 	c.ReturnErr(c.net.GetKnownNodes(&args, &reply))
 	c.Return(reply)
 }
+
+// 把HTTP连接升级为节点间协议的长连接
+func (c *NetController) Peer() {
+	if c.Ctx.Input.Header("Upgrade") != network.PeerUpgrade {
+		c.ReturnErr(errors.New("Upgrade to " + network.PeerUpgrade + " is required"))
+	}
+
+	conn, rw, err := c.Ctx.ResponseWriter.Hijack()
+	c.ReturnErr(err)
+	c.Ctx.ResponseWriter.Started = true
+
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Connection: Upgrade\r\nUpgrade: " + network.PeerUpgrade + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go network.ServePeer(conn, rw.Reader)
+}
+
+type BannedNode struct {
+	ID      string
+	Address string
+	Host    string
+	Until   int64
+}
+type ListBannedReply struct {
+	Banned []BannedNode
+}
+
+func ListBanned(ctx context.Context) ([]BannedNode, error) {
+	var reply ListBannedReply
+	err := network.CallSelf(ctx, "net/ListBanned", nil, &reply)
+	return reply.Banned, err
+}
+
+// @router /ListBanned [post]
+func (c *NetController) ListBanned() {
+	c.RequireLocal()
+	c.ParseParameter(nil)
+
+	var reply ListBannedReply
+	for _, peer := range network.ListBanned() {
+		reply.Banned = append(reply.Banned, BannedNode{peer.ID, peer.Address, peer.Host, peer.Until.Unix()})
+	}
+	sort.Slice(reply.Banned, func(i, j int) bool {
+		return reply.Banned[i].Until < reply.Banned[j].Until
+	})
+	c.Return(reply)
+}
+
+type UnbanArgs struct {
+	Node string // 节点ID、IP或者封禁时的地址
+}
+
+func Unban(ctx context.Context, node string) error {
+	args := UnbanArgs{node}
+	return network.CallSelf(ctx, "net/Unban", &args, nil)
+}
+
+// @router /Unban [post]
+func (c *NetController) Unban() {
+	c.RequireLocal()
+	var args UnbanArgs
+	c.ParseParameter(&args)
+
+	c.ReturnErr(network.Unban(args.Node))
+	c.Return(nil)
+}
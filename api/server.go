@@ -1,12 +1,16 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/YouDad/blockchain/core"
 	"github.com/YouDad/blockchain/global"
 	"github.com/YouDad/blockchain/log"
 	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
 )
 
 type ServerController struct {
@@ -16,26 +20,93 @@ type ServerController struct {
 type SendCMDArgs struct {
 	SendFrom string
 	SendTo   string
-	Amount   int64
+	Amount   types.Amount
 }
 
-func SendCMD(from, to string, amount int64) error {
+type SendCMDReply struct {
+	Raw string // 发送者是只读地址时返回未签名的交易
+}
+
+func SendCMD(ctx context.Context, from, to string, amount types.Amount) (string, error) {
 	args := SendCMDArgs{from, to, amount}
-	return network.CallSelf("server/SendCMD", &args, nil)
+	var reply SendCMDReply
+	err := network.CallSelf(ctx, "server/SendCMD", &args, &reply)
+	return reply.Raw, err
 }
 
+// @router /SendCMD [post]
 func (c *ServerController) SendCMD() {
+	c.RequireLocal()
 	var args SendCMDArgs
 	c.ParseParameter(&args)
+	c.ReturnErr(validateAddresses([]string{args.SendFrom}, args.SendTo))
 
 	set := core.GetUTXOSet(global.GetGroupByAddress(args.SendFrom))
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	if w, ok := wallets.GetWallet(args.SendFrom); ok && w.WatchOnly && !wallet.HasExternalSigner() {
+		raw, err := set.CreateRawTransaction(args.SendFrom, args.SendTo, args.Amount)
+		c.ReturnErr(err)
+		c.Return(SendCMDReply{raw.Encode()})
+	}
+
 	txn, err := set.CreateTransaction(args.SendFrom, args.SendTo, args.Amount)
 	c.ReturnErr(err)
-	c.ReturnErr(network.GetKnownNodes())
+	group := global.GetGroupByAddress(args.SendFrom)
+	// 先加入本节点的交易池，其他节点收到广播后来取
+	c.ReturnErr(addTxn(group, *txn))
+	c.ReturnErr(network.GetKnownNodes(network.Context()))
 
-	log.Debugln(global.GetGroupByAddress(args.SendFrom), global.GetGroupByPubKeyHash(txn.Vin[0].PubKey.Hash()))
+	log.Debugln(group, global.GetGroupByPubKeyHash(txn.Vin[0].PubKey.Hash()))
 	log.Debugln(txn.Hash(), *txn)
-	GossipTxn(global.GetGroupByAddress(args.SendFrom), *txn,
-		fmt.Sprintf("127.0.0.1:%s", global.Port))
-	c.Return(nil)
+	GossipTxn(group, *txn, global.SelfAddress())
+	c.Return(SendCMDReply{})
+}
+
+type CreateRawTxnArgs struct {
+	From   []string
+	To     string
+	Amount types.Amount
+}
+type CreateRawTxnReply struct {
+	Raw string
+}
+
+func CreateRawTxn(ctx context.Context, from []string, to string, amount types.Amount) (*types.RawTxn, error) {
+	args := CreateRawTxnArgs{from, to, amount}
+	var reply CreateRawTxnReply
+	err := network.CallSelf(ctx, "server/CreateRawTxn", &args, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return types.DecodeRawTxn(reply.Raw)
+}
+
+// @router /CreateRawTxn [post]
+func (c *ServerController) CreateRawTxn() {
+	c.RequireLocal()
+	var args CreateRawTxnArgs
+	c.ParseParameter(&args)
+	c.ReturnErr(validateAddresses(args.From, args.To))
+
+	set := core.GetUTXOSet(global.GetGroupByAddress(args.From[0]))
+	raw, err := set.CreateMultiRawTransaction(args.From, args.To, args.Amount)
+	c.ReturnErr(err)
+	c.Return(CreateRawTxnReply{raw.Encode()})
+}
+
+// 检查发送者和接收者的地址
+func validateAddresses(from []string, to string) error {
+	if len(from) == 0 {
+		return errors.New("No sender")
+	}
+	for _, address := range from {
+		if !wallet.ValidateAddress(address) {
+			return fmt.Errorf("Sender address %s is not valid", address)
+		}
+	}
+	if !wallet.ValidateAddress(to) {
+		return fmt.Errorf("Recipient address %s is not valid", to)
+	}
+	return nil
 }
@@ -1,28 +1,44 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/YouDad/blockchain/core"
 	"github.com/YouDad/blockchain/global"
 	"github.com/YouDad/blockchain/log"
 	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/types"
+)
+
+// 获取创世区块和版本失败时的重试次数和初始等待时间
+const (
+	syncAttempts = 5
+	syncBackoff  = time.Second
 )
 
 // 同步
-func Sync(group int) error {
+func Sync(ctx context.Context, group int) error {
 	group = group % global.MaxGroupNum
 	network.Register()
 	bc := core.GetBlockchain(group)
 
 	if bc.GetHeight() < 0 {
-		genesis, err := GetGenesis(group)
-		for err != nil {
-			log.Warn(err)
-			time.Sleep(5 * time.Second)
-			log.Warn(network.GetKnownNodes())
-			network.UpdateSortedNodes()
-			genesis, err = GetGenesis(group)
+		var genesis *types.Block
+		err := network.Retry(ctx, syncAttempts, syncBackoff, func() (err error) {
+			genesis, err = GetGenesis(ctx, group)
+			if err != nil {
+				log.Warn(err)
+				log.Warn(network.GetKnownNodes(ctx))
+				network.UpdateSortedNodes()
+			}
+			return err
+		})
+		if err != nil {
+			return err
 		}
 		bc.AddBlock(genesis)
 		core.GetUTXOSet(group).Reindex()
@@ -33,107 +49,224 @@ func Sync(group int) error {
 	lastest := bc.GetLastest()
 	var height int32
 	var address string
-	var err error
-	for {
-		height, err, address = SendVersion(group, lastest.Height, genesis.Hash(), lastest.Hash())
+	err := network.Retry(ctx, syncAttempts, syncBackoff, func() (err error) {
+		height, err, address = SendVersion(ctx, group, lastest.Height, genesis.Hash(), lastest.Hash())
 		log.Warn(err)
-		if err == nil {
-			break
-		}
-		time.Sleep(5 * time.Second)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	SyncBlocks(group, height, address)
+	SyncBlocks(ctx, group, height, address)
 	return nil
 }
 
 // 同步group组的区块，最新的区块高度是newHeight，发送者是address
-func SyncBlocks(group int, newHeight int32, address string) {
+func SyncBlocks(ctx context.Context, group int, newHeight int32, address string) {
 	log.Debugln("SyncBlocks", "{{{{{{{{")
-	syncBlocks(group, newHeight, address)
+	syncBlocks(ctx, group, newHeight, address)
 	log.Debugln("SyncBlocks", "}}}}}}}}")
 }
 
-func syncBlocks(group int, newHeight int32, address string) {
+// 区块体按窗口分给多个节点并行下载，一个窗口超时的节点不再使用
+const (
+	syncWindow       = 16
+	syncStallTimeout = 10 * time.Second
+)
+
+func syncBlocks(ctx context.Context, group int, newHeight int32, address string) {
 	bc := core.GetBlockchain(group)
 
 	global.SyncLock()
 	defer global.SyncUnlock()
-	lastestHeight := bc.GetHeight()
-	// log.Traceln("bc.GetHeight() return", lastestHeight)
-	if newHeight <= lastestHeight {
-		// 认为不需要同步
-		return
-	}
-
-	if lastestHeight == -1 {
-		// 同步不了，没有genesis
-		return
-	}
-
-	log.Debugln("SyncBlock Start!")
-	lastest := bc.GetLastest()
-	originHash := lastest.Hash()
+	for {
+		lastestHeight := bc.GetHeight()
+		// log.Traceln("bc.GetHeight() return", lastestHeight)
+		if newHeight <= lastestHeight {
+			// 认为不需要同步
+			return
+		}
 
-	// 二分同步找到差异点
-	var l int32 = 0
-	var r int32 = lastestHeight
-	for r >= l {
-		m := l + (r-l)/2
+		if lastestHeight == -1 {
+			// 同步不了，没有genesis
+			return
+		}
 
-		hash, err := CallbackGetHash(group, m, address)
+		full, err := syncHeaders(ctx, group, address)
 		if err != nil {
 			log.Warn(err)
 			return
 		}
-
-		block := bc.GetBlockByHeight(m)
-		if hash.Equal(block.Hash()) {
-			l = m + 1
-		} else {
-			r = m - 1
+		if !full {
+			return
 		}
 	}
+}
 
-	// 将lastest移到最新的相同点
-	lastest = bc.GetBlockByHeight(r)
-	if lastest == nil {
-		// 有可能r是-1
-		log.Errln("二分nil")
+// 同步一批区块头和对应的区块，返回这批区块头是否已满
+func syncHeaders(ctx context.Context, group int, address string) (bool, error) {
+	bc := core.GetBlockchain(group)
+	lastestHeight := bc.GetHeight()
+
+	log.Debugln("SyncBlock Start!")
+	headers, err := CallbackGetHeaders(ctx, group, blockLocator(bc), address)
+	if err != nil {
+		return false, err
+	}
+
+	// 对方的链不比我们的长
+	if len(headers) == 0 || headers[len(headers)-1].Height <= lastestHeight {
+		return false, nil
 	}
 
-	bc.SetLastest(lastest)
+	fork := bc.GetBlockByHeight(headers[0].Height - 1)
+	if fork == nil || !fork.Hash().Equal(headers[0].PrevHash) {
+		network.Misbehave(network.PeerID(address), network.ScoreInvalidBlock, core.ErrHeaderChain.Error())
+		return false, core.ErrHeaderChain
+	}
 
-	// 获得group组的l到newHeight高度的区块
-	lastestHash := lastest.Hash()
-	blocks, err := CallbackGetBlocks(group, l, newHeight, lastestHash, address)
+	// 先校验区块头并下载全部区块，都没问题才动本地的链
+	err = core.VerifyHeaders(headers)
 	if err != nil {
-		log.Warn(err)
-		block := bc.GetBlockByHash(originHash)
-		bc.SetLastest(block)
-		return
+		network.Misbehave(network.PeerID(address), network.ScoreInvalidBlock, err.Error())
+		return false, err
+	}
+
+	blocks, err := downloadBlocks(ctx, group, headers, address)
+	if err != nil {
+		return false, err
 	}
 
-	// 撤销原先lastestHeight到r的高度的UTXOSet集合
-	set := core.GetUTXOSet(group)
-	for i := lastestHeight; i > r; i-- {
-		block := bc.GetBlockByHeight(i)
+	// 撤销分叉点之后的区块，再追加新的区块，交易无效时恢复原来的分支
+	err = bc.SwitchBranch(fork, blocks)
+	if err != nil {
+		network.Misbehave(network.PeerID(address), network.ScoreInvalidBlock, err.Error())
+		return false, err
+	}
+	return len(headers) == maxHeaders, nil
+}
+
+// 从最新区块开始，先逐个再按指数间隔取区块哈希，最后是创世区块
+func blockLocator(bc *core.Blockchain) []types.HashValue {
+	var locator []types.HashValue
+	step := int32(1)
+	for height := bc.GetHeight(); height > 0; height -= step {
+		block := bc.GetBlockByHeight(height)
 		if block == nil {
-			log.Warnln("[FAIL] Reverse GetBlockByHeight return 0")
-			continue
+			break
+		}
+		locator = append(locator, block.Hash())
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	return append(locator, bc.GetGenesis().Hash())
+}
+
+// 负责group组的其他节点，address排在最前
+func syncPeers(group int, address string) []string {
+	peers := []string{address}
+	for _, node := range network.GetGroupNodes(group) {
+		if node.Address != address && !global.IsSelf(node.Address) {
+			peers = append(peers, node.Address)
 		}
-		set.Reverse(block)
-		bc.DeleteBlock(block)
 	}
+	return peers
+}
 
-	// 然后将后面所有区块都追加到lastest的后面
-	for _, block := range blocks {
-		if !lastestHash.Equal(block.PrevHash) {
-			break
+var errBlockMismatch = errors.New("Block does not match its header")
+
+type syncWindowResult struct {
+	index  int
+	blocks []*types.Block
+}
+
+// 按区块头并行下载区块，失败或超时的窗口交给其他节点
+func downloadBlocks(ctx context.Context, group int, headers []*types.Block, address string) ([]*types.Block, error) {
+	var windows [][]network.InvItem
+	for i := 0; i < len(headers); i += syncWindow {
+		var items []network.InvItem
+		for j := i; j < i+syncWindow && j < len(headers); j++ {
+			items = append(items, network.InvItem{Type: network.InvBlock, Group: group, Hash: headers[j].Hash()})
 		}
+		windows = append(windows, items)
+	}
 
-		bc.AddBlock(block)
-		set.Update(block)
-		lastestHash = block.Hash()
+	queue := make(chan int, len(windows))
+	results := make(chan syncWindowResult, len(windows))
+	for i := range windows {
+		queue <- i
+	}
+	defer close(queue)
+
+	var workers sync.WaitGroup
+	for _, peer := range syncPeers(group, address) {
+		workers.Add(1)
+		go func(peer string) {
+			defer workers.Done()
+			for i := range queue {
+				blocks, err := downloadWindow(ctx, peer, windows[i])
+				if err == errBlockMismatch {
+					network.Misbehave(network.PeerID(peer), network.ScoreInvalidBlock, err.Error())
+				}
+				if err != nil {
+					log.Warnf("Sync: %s stalled, %v\n", peer, err)
+					queue <- i
+					return
+				}
+				results <- syncWindowResult{i, blocks}
+			}
+		}(peer)
+	}
+
+	stalled := make(chan interface{})
+	go func() {
+		workers.Wait()
+		close(stalled)
+	}()
+
+	blocks := make([][]*types.Block, len(windows))
+	for remaining := len(windows); remaining > 0; remaining-- {
+		select {
+		case r := <-results:
+			blocks[r.index] = r.blocks
+		case <-stalled:
+			select {
+			case r := <-results:
+				blocks[r.index] = r.blocks
+			default:
+				return nil, errors.New("All peers stalled during sync")
+			}
+		}
+	}
+
+	var ret []*types.Block
+	for _, window := range blocks {
+		ret = append(ret, window...)
+	}
+	return ret, nil
+}
+
+// 向peer取得一个窗口的区块，并检查区块和区块头一致
+func downloadWindow(ctx context.Context, peer string, items []network.InvItem) ([]*types.Block, error) {
+	ctx, cancel := context.WithTimeout(ctx, syncStallTimeout)
+	defer cancel()
+
+	var reply GetDataReply
+	err := network.CallBack(ctx, peer, "db/GetData", &items, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply.Blocks) != len(items) {
+		return nil, errors.New(fmt.Sprintf("Got %d of %d blocks", len(reply.Blocks), len(items)))
+	}
+
+	for i, block := range reply.Blocks {
+		if !block.Hash().Equal(items[i].Hash) || len(block.Txns) == 0 ||
+			!core.NewTxnMerkleTree(block.Txns).RootNode.Data.Equal(block.MerkleRoot) {
+			return nil, errBlockMismatch
+		}
 	}
+	return reply.Blocks, nil
 }
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -20,7 +21,7 @@ type SendVersionReply = types.Version
 
 const Version int = 0x00
 
-func SendVersion(group int, nowHeight int32, rootHash, nowHash types.HashValue) (int32, error, string) {
+func SendVersion(ctx context.Context, group int, nowHeight int32, rootHash, nowHash types.HashValue) (int32, error, string) {
 	var reply SendVersionReply
 	args := SendVersionArgs{
 		Group:    group,
@@ -29,7 +30,7 @@ func SendVersion(group int, nowHeight int32, rootHash, nowHash types.HashValue)
 		RootHash: rootHash,
 		NowHash:  nowHash,
 	}
-	err, address := network.CallInnerGroup("version/SendVersion", &args, &reply)
+	err, address := network.CallInnerGroup(ctx, "version/SendVersion", &args, &reply)
 
 	if err != nil {
 		return 0, err, address
@@ -53,10 +54,10 @@ func SendVersion(group int, nowHeight int32, rootHash, nowHash types.HashValue)
 	return reply.Height, err, address
 }
 
-func GetVersion() (types.Version, error) {
+func GetVersion(ctx context.Context) (types.Version, error) {
 	reply := SendVersionReply{Group: -1}
 
-	err := network.CallSelf("version/SendVersion", &reply, &reply)
+	err := network.CallSelf(ctx, "version/SendVersion", &reply, &reply)
 	return reply, err
 }
 
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/YouDad/blockchain/core"
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
+)
+
+type WalletController struct {
+	BaseController
+}
+
+type UnlockWalletArgs struct {
+	Passphrase string
+	Timeout    int64
+}
+
+func UnlockWallet(ctx context.Context, passphrase string, timeout int64) error {
+	args := UnlockWalletArgs{passphrase, timeout}
+	return network.CallSelf(ctx, "wallet/Unlock", &args, nil)
+}
+
+// @router /Unlock [post]
+func (c *WalletController) Unlock() {
+	var args UnlockWalletArgs
+	c.RequireLocal()
+	c.ParseParameter(&args)
+	if args.Timeout <= 0 {
+		c.ReturnErr(errors.New("Timeout must be positive"))
+	}
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	c.ReturnErr(wallets.Unlock(args.Passphrase, time.Duration(args.Timeout)*time.Second))
+	c.Return(nil)
+}
+
+func LockWallet(ctx context.Context) error {
+	return network.CallSelf(ctx, "wallet/Lock", nil, nil)
+}
+
+// @router /Lock [post]
+func (c *WalletController) Lock() {
+	c.RequireLocal()
+	c.ParseParameter(nil)
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	wallets.Lock()
+	c.Return(nil)
+}
+
+type HistoryArgs struct {
+	Address string
+}
+type HistoryReply struct {
+	Txns []types.WalletTxn
+}
+
+// 查询钱包的交易记录，address为空时查询所有地址
+func History(ctx context.Context, address string) ([]types.WalletTxn, error) {
+	args := HistoryArgs{address}
+	var reply HistoryReply
+	err := network.CallSelf(ctx, "wallet/History", &args, &reply)
+	return reply.Txns, err
+}
+
+// @router /History [post]
+func (c *WalletController) History() {
+	var args HistoryArgs
+	var reply HistoryReply
+	c.RequireLocal()
+	c.ParseParameter(&args)
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+
+	groups := make([]int, 0, global.MaxGroupNum)
+	if args.Address != "" {
+		if !wallet.ValidateAddress(args.Address) {
+			c.ReturnErr(fmt.Errorf("Address %s is not valid", args.Address))
+		}
+		groups = append(groups, global.GetGroupByAddress(args.Address))
+	} else {
+		for group := 0; group < global.MaxGroupNum; group++ {
+			groups = append(groups, group)
+		}
+	}
+
+	// 只有本地有数据库的组才有记录
+	for _, group := range groups {
+		if global.DatabaseExists(group) {
+			reply.Txns = append(reply.Txns, core.GetWalletHistory(group).GetTxns(args.Address)...)
+		}
+	}
+
+	for i := range reply.Txns {
+		wt := &reply.Txns[i]
+		wt.Label = wallets.GetLabel(wt.Hash.String())
+		if wt.Label == "" {
+			wt.Label = wallets.GetLabel(wt.Address)
+		}
+	}
+
+	sort.SliceStable(reply.Txns, func(i, j int) bool {
+		return reply.Txns[i].Timestamp > reply.Txns[j].Timestamp
+	})
+	c.Return(reply)
+}
+
+type SetLabelArgs struct {
+	Key   string
+	Label string
+}
+
+// 给地址或交易哈希设置标签
+func SetLabel(ctx context.Context, key, label string) error {
+	args := SetLabelArgs{key, label}
+	return network.CallSelf(ctx, "wallet/SetLabel", &args, nil)
+}
+
+// @router /SetLabel [post]
+func (c *WalletController) SetLabel() {
+	var args SetLabelArgs
+	c.RequireLocal()
+	c.ParseParameter(&args)
+
+	if hash, err := hex.DecodeString(args.Key); (err != nil || len(hash) != 32) &&
+		!wallet.ValidateAddress(args.Key) {
+		c.ReturnErr(fmt.Errorf("%s is neither an address nor a transaction hash", args.Key))
+	}
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	wallets.SetLabel(args.Key, args.Label)
+	c.ReturnErr(wallets.SaveToFile())
+	c.Return(nil)
+}
+
+// 扫描本地的链，重建钱包的交易记录
+func RescanWallet(ctx context.Context) error {
+	return network.CallSelf(ctx, "wallet/Rescan", nil, nil)
+}
+
+// @router /Rescan [post]
+func (c *WalletController) Rescan() {
+	c.RequireLocal()
+	c.ParseParameter(nil)
+
+	global.UpdateLock()
+	defer global.UpdateUnlock()
+	for group := 0; group < global.MaxGroupNum; group++ {
+		if global.DatabaseExists(group) {
+			core.GetWalletHistory(group).Reindex()
+		}
+	}
+	c.Return(nil)
+}
+
+type ImportWatchOnlyArgs struct {
+	Address string
+	PubKey  types.PublicKey
+}
+type ImportWatchOnlyReply struct {
+	Address string
+}
+
+// 导入只读地址或公钥
+func ImportWatchOnly(ctx context.Context, address string, pubKey types.PublicKey) (string, error) {
+	args := ImportWatchOnlyArgs{address, pubKey}
+	var reply ImportWatchOnlyReply
+	err := network.CallSelf(ctx, "wallet/ImportWatchOnly", &args, &reply)
+	return reply.Address, err
+}
+
+// @router /ImportWatchOnly [post]
+func (c *WalletController) ImportWatchOnly() {
+	var args ImportWatchOnlyArgs
+	c.RequireLocal()
+	c.ParseParameter(&args)
+
+	w, err := wallet.NewWatchOnlyWallet(args.Address, args.PubKey)
+	c.ReturnErr(err)
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	c.ReturnErr(wallets.ImportWatchOnly(w))
+	c.ReturnErr(wallets.SaveToFile())
+
+	// 重建该组的交易记录
+	group := w.Group()
+	if global.DatabaseExists(group) {
+		global.UpdateLock()
+		defer global.UpdateUnlock()
+		core.GetWalletHistory(group).Reindex()
+	}
+	c.Return(ImportWatchOnlyReply{w.String()})
+}
+
+type ExportKeyArgs struct {
+	Address string
+}
+type ExportKeyReply struct {
+	Key string
+}
+
+// 导出地址的私钥
+func ExportKey(ctx context.Context, address string) (string, error) {
+	args := ExportKeyArgs{address}
+	var reply ExportKeyReply
+	err := network.CallSelf(ctx, "wallet/ExportKey", &args, &reply)
+	return reply.Key, err
+}
+
+// @router /ExportKey [post]
+func (c *WalletController) ExportKey() {
+	var args ExportKeyArgs
+	c.RequireLocal()
+	c.ParseParameter(&args)
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	sk, err := wallets.GetPrivateKey(args.Address)
+	c.ReturnErr(err)
+	c.Return(ExportKeyReply{wallet.EncodePrivateKey(sk)})
+}
+
+type ImportKeyArgs struct {
+	Key string
+}
+type ImportKeyReply struct {
+	Address string
+}
+
+// 导入私钥并重新扫描该地址所在组的链
+func ImportKey(ctx context.Context, key string) (string, error) {
+	args := ImportKeyArgs{key}
+	var reply ImportKeyReply
+	err := network.CallSelf(ctx, "wallet/ImportKey", &args, &reply)
+	return reply.Address, err
+}
+
+// @router /ImportKey [post]
+func (c *WalletController) ImportKey() {
+	var args ImportKeyArgs
+	c.RequireLocal()
+	c.ParseParameter(&args)
+
+	sk, err := wallet.DecodePrivateKey(args.Key)
+	c.ReturnErr(err)
+	w := wallet.NewWalletFromPrivateKey(sk)
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	c.ReturnErr(wallets.ImportKey(w))
+	c.ReturnErr(wallets.SaveToFile())
+
+	// UTXO集按公钥哈希索引，不受影响，只需重建该组的交易记录
+	group := w.Group()
+	if global.DatabaseExists(group) {
+		global.UpdateLock()
+		defer global.UpdateUnlock()
+		core.GetWalletHistory(group).Reindex()
+	}
+	c.Return(ImportKeyReply{w.String()})
+}
+
+type SignMessageArgs struct {
+	Address string
+	Message string
+}
+type SignMessageReply struct {
+	Signature string
+}
+
+// 用地址的私钥签名消息
+func SignMessage(ctx context.Context, address, message string) (string, error) {
+	args := SignMessageArgs{address, message}
+	var reply SignMessageReply
+	err := network.CallSelf(ctx, "wallet/SignMessage", &args, &reply)
+	return reply.Signature, err
+}
+
+// @router /SignMessage [post]
+func (c *WalletController) SignMessage() {
+	var args SignMessageArgs
+	c.RequireLocal()
+	c.ParseParameter(&args)
+
+	wallets, err := wallet.GetWallets()
+	c.ReturnErr(err)
+	signer, err := wallets.GetSigner(args.Address)
+	c.ReturnErr(err)
+	signature, err := wallet.SignMessage(signer, args.Message)
+	c.ReturnErr(err)
+	c.Return(SignMessageReply{signature})
+}
+
+type VerifyMessageArgs struct {
+	Address   string
+	Signature string
+	Message   string
+}
+
+// 验证消息签名，不需要钱包
+func VerifyMessage(ctx context.Context, address, signature, message string) error {
+	args := VerifyMessageArgs{address, signature, message}
+	return network.CallSelf(ctx, "wallet/VerifyMessage", &args, nil)
+}
+
+// @router /VerifyMessage [post]
+func (c *WalletController) VerifyMessage() {
+	var args VerifyMessageArgs
+	c.ParseParameter(&args)
+
+	c.ReturnErr(wallet.VerifyMessage(args.Address, args.Signature, args.Message))
+	c.Return(nil)
+}
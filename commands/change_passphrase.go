@@ -0,0 +1,18 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/spf13/cobra"
+)
+
+var ChangePassphraseCmd = &cobra.Command{
+	Use:   "change_passphrase",
+	Short: "Re-encrypt the wallet file with a new passphrase, both read from the terminal",
+	Run: func(cmd *cobra.Command, args []string) {
+		wallets := getWalletsForUpdate()
+		oldPassphrase := readPassphrase("Passphrase: ")
+		log.Err(wallets.ChangePassphrase(oldPassphrase, readNewPassphrase()))
+		log.Err(wallets.SaveToFile())
+		log.Infoln("Passphrase changed!")
+	},
+}
@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	combinePartialIn  []string
+	combinePartialOut string
+)
+
+func init() {
+	CombinePartialTxnCmd.Flags().StringSliceVar(&combinePartialIn, "in", nil, "Partial transaction files, can be repeated")
+	CombinePartialTxnCmd.Flags().StringVar(&combinePartialOut, "out", "", "File to write the combined partial transaction")
+	CombinePartialTxnCmd.MarkFlagRequired("in")
+	CombinePartialTxnCmd.MarkFlagRequired("out")
+}
+
+var CombinePartialTxnCmd = &cobra.Command{
+	Use:   "combine_partial_txn",
+	Short: "Combine the signatures of several partial transaction files",
+	Run: func(cmd *cobra.Command, args []string) {
+		partial := readPartialTxn(combinePartialIn[0])
+		for _, filename := range combinePartialIn[1:] {
+			log.Err(partial.Combine(readPartialTxn(filename)))
+		}
+		writePartialTxn(combinePartialOut, partial)
+	},
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	partialFrom   []string
+	partialTo     string
+	partialAmount string
+	partialMemo   string
+	partialOut    string
+)
+
+func init() {
+	CreatePartialTxnCmd.Flags().StringSliceVar(&partialFrom, "from", nil, "Source wallet addresses, can be repeated")
+	CreatePartialTxnCmd.Flags().StringVar(&partialTo, "to", "", "Destination wallet address")
+	CreatePartialTxnCmd.Flags().StringVar(&partialAmount, "amount", "", "Amount to send, such as 7.5")
+	CreatePartialTxnCmd.Flags().StringVar(&partialMemo, "memo", "", "Memo passed to the signers")
+	CreatePartialTxnCmd.Flags().StringVar(&partialOut, "out", "", "File to write the partial transaction")
+	CreatePartialTxnCmd.MarkFlagRequired("from")
+	CreatePartialTxnCmd.MarkFlagRequired("to")
+	CreatePartialTxnCmd.MarkFlagRequired("amount")
+	CreatePartialTxnCmd.MarkFlagRequired("out")
+}
+
+func readPartialTxn(filename string) *types.PartialTxn {
+	bytes, err := ioutil.ReadFile(filename)
+	log.Err(err)
+
+	var partial types.PartialTxn
+	log.Err(json.Unmarshal(bytes, &partial))
+	log.Err(partial.Check())
+	return &partial
+}
+
+// 有Metadata这个map，jsoniter编码map会出错，用标准库
+func writePartialTxn(filename string, partial *types.PartialTxn) {
+	content, err := json.MarshalIndent(partial, "", "  ")
+	log.Err(err)
+	log.Err(ioutil.WriteFile(filename, content, 0644))
+	log.Infof("Signed %d/%d inputs, written to %s\n",
+		partial.SignedCount(), len(partial.Txn.Vin), filename)
+}
+
+var CreatePartialTxnCmd = &cobra.Command{
+	Use:   "create_partial_txn",
+	Short: "Create a partially signed transaction file spending from several FROM addresses",
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, from := range partialFrom {
+			if !wallet.ValidateAddress(from) {
+				log.Errln("Sender address is not valid", from)
+			}
+		}
+
+		if !wallet.ValidateAddress(partialTo) {
+			log.Errln("Recipient address is not valid")
+		}
+
+		amount := parseAmount(partialAmount)
+		global.Address = partialFrom[0]
+		network.Register()
+		raw, err := api.CreateRawTxn(network.Context(), partialFrom, partialTo, amount)
+		if err != nil {
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+			raw, err = api.CreateRawTxn(network.Context(), partialFrom, partialTo, amount)
+			log.Err(err)
+		}
+
+		partial := types.NewPartialTxn(*raw)
+		if partialMemo != "" {
+			partial.Metadata["memo"] = partialMemo
+		}
+		writePartialTxn(partialOut, partial)
+	},
+}
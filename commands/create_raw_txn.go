@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	createRawTo     string
+	createRawAmount string
+)
+
+func init() {
+	CreateRawTxnCmd.Flags().StringVar(&global.Address, "from", "", "Source wallet address")
+	CreateRawTxnCmd.Flags().StringVar(&createRawTo, "to", "", "Destination wallet address")
+	CreateRawTxnCmd.Flags().StringVar(&createRawAmount, "amount", "", "Amount to send, such as 7.5")
+	CreateRawTxnCmd.MarkFlagRequired("from")
+	CreateRawTxnCmd.MarkFlagRequired("to")
+	CreateRawTxnCmd.MarkFlagRequired("amount")
+}
+
+var CreateRawTxnCmd = &cobra.Command{
+	Use:   "create_raw_txn",
+	Short: "Create an unsigned transaction sending AMOUNT from FROM to TO",
+	Run: func(cmd *cobra.Command, args []string) {
+		if !wallet.ValidateAddress(global.Address) {
+			log.Errln("Sender address is not valid")
+		}
+
+		if !wallet.ValidateAddress(createRawTo) {
+			log.Errln("Recipient address is not valid")
+		}
+
+		amount := parseAmount(createRawAmount)
+		network.Register()
+		from := []string{global.Address}
+		raw, err := api.CreateRawTxn(network.Context(), from, createRawTo, amount)
+		if err != nil {
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+			raw, err = api.CreateRawTxn(network.Context(), from, createRawTo, amount)
+			log.Err(err)
+		}
+		log.Infof("Raw transaction: %s\n", raw.Encode())
+	},
+}
@@ -1,32 +1,57 @@
 package commands
 
 import (
+	"fmt"
+
 	"github.com/YouDad/blockchain/global"
 	"github.com/YouDad/blockchain/log"
 	"github.com/YouDad/blockchain/wallet"
 	"github.com/spf13/cobra"
 )
 
-var specified int
+var (
+	specified      int
+	createMnemonic bool
+)
 
 func init() {
 	CreateWalletCmd.Flags().IntVar(&specified, "specified", -1, "use specified group")
+	CreateWalletCmd.Flags().BoolVar(&createMnemonic, "mnemonic", false,
+		"Create an HD seed with a mnemonic backup phrase")
 }
 
 var CreateWalletCmd = &cobra.Command{
 	Use:   "create_wallet",
 	Short: "Generates a new key-pair and saves it into the wallet file",
 	Run: func(cmd *cobra.Command, args []string) {
-		ws, err := wallet.GetWallets()
-		log.Err(err)
-		w := wallet.NewWallet()
-		global.Address = w.String()
-		for specified != -1 && specified != global.GetGroup() {
+		ws := getWalletsForUpdate()
+		if createMnemonic {
+			mnemonic, err := wallet.NewMnemonic()
+			log.Err(err)
+			log.Err(ws.SetSeed(wallet.MnemonicToSeed(mnemonic, "")))
+			// 助记词只打印到终端，不写进日志文件
+			fmt.Printf("Your mnemonic: %s\n", mnemonic)
+		}
+
+		var w *wallet.Wallet
+		if wallet.HasSeed() {
+			// 有HD种子时按组确定性地派生
+			var err error
+			if specified != -1 {
+				w, err = ws.NewHDWalletInGroup(specified)
+			} else {
+				w, err = ws.NewHDWallet()
+			}
+			log.Err(err)
+		} else {
 			w = wallet.NewWallet()
-			global.Address = w.String()
+			for specified != -1 && specified != global.GetGroupByAddress(w.String()) {
+				w = wallet.NewWallet()
+			}
 		}
+		global.Address = w.String()
 		ws[w.String()] = w
-		ws.SaveToFile()
+		log.Err(ws.SaveToFile())
 
 		log.Infof("Your new address: %s\n", global.Address)
 	},
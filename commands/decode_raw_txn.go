@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var decodeRaw string
+
+func init() {
+	DecodeRawTxnCmd.Flags().StringVar(&decodeRaw, "raw", "", "Raw transaction to decode")
+	DecodeRawTxnCmd.MarkFlagRequired("raw")
+}
+
+var DecodeRawTxnCmd = &cobra.Command{
+	Use:   "decode_raw_txn",
+	Short: "Print the inputs and outputs of a raw transaction",
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, err := types.DecodeRawTxn(decodeRaw)
+		log.Err(err)
+
+		var sumIn, sumOut types.Amount
+		log.Infof("Hash: %s\n", raw.Txn.Hash())
+		for i, vin := range raw.Txn.Vin {
+			prevOut := raw.PrevOuts[i]
+			log.Infof("Vin[%d]: %s:%d %s %s signed: %t\n", i, vin.VoutHash, vin.VoutIndex,
+				wallet.PubKeyHashToAddress(prevOut.PubKeyHash), vin.VoutValue, len(vin.Signature) != 0)
+			sumIn, err = sumIn.Add(vin.VoutValue)
+			log.Err(err)
+		}
+
+		for i, vout := range raw.Txn.Vout {
+			log.Infof("Vout[%d]: %s %s\n", i, wallet.PubKeyHashToAddress(vout.PubKeyHash), vout.Value)
+			sumOut, err = sumOut.Add(vout.Value)
+			log.Err(err)
+		}
+
+		log.Infof("Input: %s, Output: %s, Complete: %t\n", sumIn, sumOut, raw.IsComplete())
+	},
+}
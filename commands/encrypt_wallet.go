@@ -0,0 +1,17 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/spf13/cobra"
+)
+
+var EncryptWalletCmd = &cobra.Command{
+	Use:   "encrypt_wallet",
+	Short: "Encrypt the private keys of the wallet file with a passphrase read from the terminal",
+	Run: func(cmd *cobra.Command, args []string) {
+		wallets := getWalletsForUpdate()
+		log.Err(wallets.Encrypt(readNewPassphrase()))
+		log.Err(wallets.SaveToFile())
+		log.Infoln("Wallet encrypted!")
+	},
+}
@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var exportAddress string
+
+func init() {
+	ExportKeyCmd.Flags().StringVar(&exportAddress, "address", "", "Address whose private key to export")
+	ExportKeyCmd.MarkFlagRequired("address")
+}
+
+var ExportKeyCmd = &cobra.Command{
+	Use:   "export_key",
+	Short: "Exports the private key of an address as checksummed text",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			getWallets()
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		key, err := api.ExportKey(network.Context(), exportAddress)
+		log.Err(err)
+		log.Infof("Private key: %s\n", key)
+	},
+}
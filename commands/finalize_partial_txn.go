@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/spf13/cobra"
+)
+
+var finalizePartialIn string
+
+func init() {
+	FinalizePartialTxnCmd.Flags().StringVar(&finalizePartialIn, "in", "", "Completely signed partial transaction file")
+	FinalizePartialTxnCmd.MarkFlagRequired("in")
+}
+
+var FinalizePartialTxnCmd = &cobra.Command{
+	Use:   "finalize_partial_txn",
+	Short: "Turn a completely signed partial transaction into a raw transaction for send_raw_txn",
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, err := readPartialTxn(finalizePartialIn).Finalize()
+		log.Err(err)
+		log.Infof("Raw transaction: %s\n", raw.Encode())
+	},
+}
@@ -18,14 +18,14 @@ var GetBalanceCmd = &cobra.Command{
 	Short: "Get balance of ADDRESS",
 	Run: func(cmd *cobra.Command, args []string) {
 		network.Register()
-		balance, err := api.GetBalance(global.Address)
+		balance, err := api.GetBalance(network.Context(), global.Address)
 		if err != nil {
 			network.Register()
 			go network.StartServer(api.Sync)
 			<-network.ServerReady
-			balance, err = api.GetBalance(global.Address)
+			balance, err = api.GetBalance(network.Context(), global.Address)
 			log.Err(err)
 		}
-		log.Infof("Balance of '%s': %d\n", global.Address, balance)
+		log.Infof("Balance of '%s': %s\n", global.Address, balance)
 	},
 }
@@ -18,12 +18,12 @@ var GetVersionCmd = &cobra.Command{
 	Short: "Print version information the blocks of the blockchain",
 	Run: func(cmd *cobra.Command, args []string) {
 		network.Register()
-		version, err := api.GetVersion()
+		version, err := api.GetVersion(network.Context())
 		if err != nil {
 			network.Register()
 			go network.StartServer(api.Sync)
 			<-network.ServerReady
-			version, err = api.GetVersion()
+			version, err = api.GetVersion(network.Context())
 			log.Err(err)
 		}
 		log.Infoln(version)
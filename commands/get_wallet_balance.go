@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/types"
+	"github.com/spf13/cobra"
+)
+
+var GetWalletBalanceCmd = &cobra.Command{
+	Use:   "get_wallet_balance",
+	Short: "Get balances of the wallet's addresses in every group",
+	Run: func(cmd *cobra.Command, args []string) {
+		ws := getWallets()
+
+		// 按组整理钱包中的地址，包括只读地址
+		addresses := make([][]string, global.MaxGroupNum)
+		for address, w := range ws {
+			group := w.Group()
+			addresses[group] = append(addresses[group], address)
+		}
+
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+		log.Warn(network.GetKnownNodesAnonymously(network.Context()))
+
+		var total types.Amount
+		for group := 0; group < global.MaxGroupNum; group++ {
+			if len(addresses[group]) == 0 {
+				log.Infof("Group %d: %s\n", group, types.Amount(0))
+				continue
+			}
+
+			balances, err := api.GetBalances(network.Context(), group, addresses[group])
+			if err != nil {
+				log.Warnf("Group %d: unavailable, %v\n", group, err)
+				continue
+			}
+
+			var sum types.Amount
+			for i, balance := range balances {
+				address := addresses[group][i]
+				if ws[address].WatchOnly {
+					log.Infof("Balance of '%s' (watch-only): %s\n", address, balance)
+				} else if ws[address].Internal {
+					log.Infof("Balance of '%s' (change): %s\n", address, balance)
+				} else {
+					log.Infof("Balance of '%s': %s\n", address, balance)
+				}
+				sum, err = sum.Add(balance)
+				log.Err(err)
+			}
+			log.Infof("Group %d: %s\n", group, sum)
+
+			total, err = total.Add(sum)
+			log.Err(err)
+		}
+		log.Infof("Total: %s\n", total)
+	},
+}
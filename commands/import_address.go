@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"encoding/hex"
+
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	importAddress string
+	importPubKey  string
+)
+
+func init() {
+	ImportAddressCmd.Flags().StringVar(&importAddress, "address", "", "Address to watch")
+	ImportAddressCmd.Flags().StringVar(&importPubKey, "pubkey", "", "Public key to watch, in hex")
+}
+
+var ImportAddressCmd = &cobra.Command{
+	Use:   "import_address",
+	Short: "Imports an address or a public key into the wallet as watch-only",
+	Run: func(cmd *cobra.Command, args []string) {
+		if importAddress == "" && importPubKey == "" {
+			log.Errln("Need --address or --pubkey")
+		}
+
+		pubKey, err := hex.DecodeString(importPubKey)
+		log.Err(err)
+
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			getWallets()
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		address, err := api.ImportWatchOnly(network.Context(), importAddress, types.PublicKey(pubKey))
+		log.Err(err)
+		log.Infof("Watch-only address: %s\n", address)
+	},
+}
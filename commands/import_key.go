@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var importKey string
+
+func init() {
+	ImportKeyCmd.Flags().StringVar(&importKey, "key", "", "Private key printed by export_key")
+	ImportKeyCmd.MarkFlagRequired("key")
+}
+
+var ImportKeyCmd = &cobra.Command{
+	Use:   "import_key",
+	Short: "Imports a private key into the wallet and rescans its history",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			getWallets()
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		address, err := api.ImportKey(network.Context(), importKey)
+		log.Err(err)
+		log.Infof("Imported address: %s\n", address)
+	},
+}
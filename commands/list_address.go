@@ -13,8 +13,11 @@ var ListAddressCmd = &cobra.Command{
 		wallets, err := wallet.GetWallets()
 		log.Err(err)
 
-		for address := range wallets {
-			log.Infoln(address)
+		// 找零地址不用于收款，不列出
+		for address, w := range wallets {
+			if !w.Internal {
+				log.Infoln(address)
+			}
 		}
 	},
 }
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var ListBannedCmd = &cobra.Command{
+	Use:   "list_banned",
+	Short: "Lists peers banned for misbehavior and when their bans expire",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		banned, err := api.ListBanned(network.Context())
+		log.Err(err)
+		for _, node := range banned {
+			log.Infof("%s (%s %s) until %s\n", node.ID, node.Address, node.Host, time.Unix(node.Until, 0).Format(time.RFC3339))
+		}
+		log.Infof("Banned: %d\n", len(banned))
+	},
+}
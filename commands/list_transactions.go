@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"strings"
+
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var listRescan bool
+
+func init() {
+	ListTransactionsCmd.Flags().StringVar(&global.Address, "address", "",
+		"Only list transactions of this address")
+	ListTransactionsCmd.Flags().BoolVar(&listRescan, "rescan", false,
+		"Rebuild the wallet history from the local chain first")
+}
+
+var ListTransactionsCmd = &cobra.Command{
+	Use:   "list_transactions",
+	Short: "Lists incoming and outgoing transactions of the wallet",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			getWallets()
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		if listRescan {
+			log.Err(api.RescanWallet(network.Context()))
+		}
+
+		txns, err := api.History(network.Context(), global.Address)
+		log.Err(err)
+		for _, wt := range txns {
+			counterparty := strings.Join(wt.Counterparty, ",")
+			if wt.Coinbase {
+				counterparty = "coinbase"
+			}
+			log.Infof("%s %s %s group=%d height=%d confirmations=%d counterparty=%s label=%s\n",
+				wt.Hash, wt.Address, wt.Amount, wt.Group, wt.Height, wt.Confirmations,
+				counterparty, wt.Label)
+		}
+		log.Infof("Transactions: %d\n", len(txns))
+	},
+}
@@ -78,7 +78,7 @@ var MiningCmd = &cobra.Command{
 
 							for group := range groups {
 								api.GossipRelayTxn(global.GetGroup(), group,
-									newBlock.Height, path, txn, "127.0.0.1:"+global.Port)
+									newBlock.Height, path, txn, global.SelfAddress())
 								time.Sleep(time.Second / 5)
 							}
 						}
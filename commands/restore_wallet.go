@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	restoreMnemonic string
+	restoreGapLimit int
+)
+
+func init() {
+	RestoreWalletCmd.Flags().StringVar(&restoreMnemonic, "mnemonic", "", "Mnemonic backup phrase")
+	RestoreWalletCmd.Flags().IntVar(&restoreGapLimit, "gap_limit", 20,
+		"Stop rescanning after this many consecutive unused addresses in every group")
+	RestoreWalletCmd.MarkFlagRequired("mnemonic")
+}
+
+// 按组查询地址是否使用过，有一个组查询不到时失败，不能把查不到的地址当作未使用
+func findUsedAddresses(addresses []string) ([]bool, error) {
+	indexes := make(map[int][]int)
+	for i, address := range addresses {
+		group := global.GetGroupByAddress(address)
+		indexes[group] = append(indexes[group], i)
+	}
+
+	used := make([]bool, len(addresses))
+	for group, index := range indexes {
+		groupAddresses := make([]string, len(index))
+		for i, j := range index {
+			groupAddresses[i] = addresses[j]
+		}
+
+		groupUsed, err := api.FindUsedAddresses(network.Context(), group, groupAddresses)
+		if err != nil {
+			return nil, fmt.Errorf("Group %d is unavailable: %v", group, err)
+		}
+		for i, j := range index {
+			used[j] = i < len(groupUsed) && groupUsed[i]
+		}
+	}
+	return used, nil
+}
+
+var RestoreWalletCmd = &cobra.Command{
+	Use:   "restore_wallet",
+	Short: "Restores the HD seed from a mnemonic and rescans the chain for its addresses",
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Err(wallet.ValidateMnemonic(restoreMnemonic))
+
+		ws := getWalletsForUpdate()
+		log.Err(ws.SetSeed(wallet.MnemonicToSeed(restoreMnemonic, "")))
+
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+		log.Warn(network.GetKnownNodesAnonymously(network.Context()))
+
+		found, err := ws.RescanHD(restoreGapLimit, findUsedAddresses)
+		log.Err(err)
+		log.Err(ws.SaveToFile())
+
+		for address, w := range ws {
+			if w.HD {
+				log.Infof("Restored address: %s %s\n", address, w.HDPath())
+			}
+		}
+		log.Infof("Used addresses: %d\n", found)
+	},
+}
@@ -1,13 +1,25 @@
 package commands
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/YouDad/blockchain/core"
 	"github.com/YouDad/blockchain/global"
 	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var (
-	logLevel uint
+	logLevel     uint
+	unlockWallet bool
+	dataDir      string
+	signerTarget string
 )
 
 func init() {
@@ -18,6 +30,28 @@ func init() {
 		"Verbose information 0~3")
 	RootCmd.PersistentFlags().IntVarP(&global.MaxGroupNum, "max_group_number", "g", 4,
 		"Group hash's max group number, must bigger than 0")
+	RootCmd.PersistentFlags().Var(&core.RelayPolicy.DustLimit, "dust_limit",
+		"Relay policy: minimum value of a transaction output in coins")
+	RootCmd.PersistentFlags().IntVar(&core.RelayPolicy.MaxTxnSize, "max_txn_size",
+		core.RelayPolicy.MaxTxnSize, "Relay policy: maximum encoded size of a transaction")
+	RootCmd.PersistentFlags().IntVar(&core.RelayPolicy.MaxTxnInputs, "max_txn_inputs",
+		core.RelayPolicy.MaxTxnInputs, "Relay policy: maximum inputs of a transaction")
+	RootCmd.PersistentFlags().IntVar(&core.RelayPolicy.MaxTxnOutputs, "max_txn_outputs",
+		core.RelayPolicy.MaxTxnOutputs, "Relay policy: maximum outputs of a transaction")
+	RootCmd.PersistentFlags().BoolVar(&unlockWallet, "unlock", false,
+		"Ask for the passphrase to unlock the encrypted wallet file")
+	RootCmd.PersistentFlags().StringVar(&dataDir, "datadir", "",
+		"Directory of the wallet file, databases and logs, default is current directory")
+	RootCmd.PersistentFlags().StringVar(&signerTarget, "signer", "",
+		"External signer for watch-only addresses, unix:<socket> or exec:<command>")
+	RootCmd.PersistentFlags().StringSliceVar(&global.Seeds, "seed", nil,
+		"Address of a node to bootstrap from, id@host:port also checks its node ID, can be repeated, default is seeds in conf/app.conf")
+	RootCmd.PersistentFlags().StringVar(&global.ExternalAddress, "external-address", "",
+		"Address other nodes use to reach this node, default is learned from peers")
+	RootCmd.PersistentFlags().StringVar(&global.Listen, "listen", "",
+		"Address the service listens on, default is 0.0.0.0:<port>")
+	RootCmd.PersistentFlags().StringVar(&global.PeerEncryption, "peer-encryption", "",
+		"Encryption of connections to other nodes: off, on or required, default is peer_encryption in conf/app.conf or on")
 }
 
 var RootCmd = &cobra.Command{
@@ -25,5 +59,83 @@ var RootCmd = &cobra.Command{
 	Short: "Blockchain coin Application",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		log.Register(logLevel, global.Port)
+		if dataDir != "" {
+			log.Err(global.SetDataDir(dataDir))
+			log.Err(log.SetOutputFile(global.DataPath(fmt.Sprintf("blockchain%s.log", global.Port))))
+		}
+		if signerTarget != "" {
+			log.Err(wallet.SetExternalSigner(signerTarget))
+		}
 	},
 }
+
+// 解析--amount，和余额一样以币为单位，最多AmountDecimals位小数
+func parseAmount(str string) types.Amount {
+	amount, err := types.ParseAmount(str)
+	if err != nil {
+		log.Errln(fmt.Sprintf("Amount %q is not valid: %v", str, err))
+	}
+	return amount
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// 读取口令，终端中不回显，不是终端时从标准输入读一行，口令不能出现在命令行参数中
+func readPassphrase(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		passphrase, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		log.Err(err)
+		return string(passphrase)
+	}
+
+	line, err := stdin.ReadString('\n')
+	if line == "" {
+		log.Err(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// 询问是否继续，回答y或yes时返回true
+func readConfirmation(prompt string) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if line == "" {
+		log.Err(err)
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+// 读取新口令，要求输入两次
+func readNewPassphrase() string {
+	passphrase := readPassphrase("New passphrase: ")
+	if passphrase == "" {
+		log.Errln("Passphrase is empty")
+	}
+	if readPassphrase("Repeat new passphrase: ") != passphrase {
+		log.Errln("Passphrases don't match")
+	}
+	return passphrase
+}
+
+// 读取钱包，钱包加密时用--unlock解锁
+func getWallets() wallet.Wallets {
+	wallets, err := wallet.GetWallets()
+	log.Err(err)
+	if wallet.IsLocked() && unlockWallet {
+		log.Err(wallets.Unlock(readPassphrase("Passphrase: "), 0))
+	}
+	return wallets
+}
+
+// 要修改钱包文件的命令在读取前锁定数据目录，节点运行时不能修改，
+// 读取和保存之间钱包文件也不会被节点改写
+func getWalletsForUpdate() wallet.Wallets {
+	if err := global.LockDataDir(); err != nil {
+		log.Errln(fmt.Sprintf("%v, stop the node first", err))
+	}
+	return getWallets()
+}
@@ -13,14 +13,14 @@ import (
 
 var (
 	sendTo     string
-	sendAmount int64
+	sendAmount string
 	sendMine   bool
 )
 
 func init() {
 	SendCmd.Flags().StringVar(&global.Address, "from", "", "Source wallet address")
 	SendCmd.Flags().StringVar(&sendTo, "to", "", "Destination wallet address")
-	SendCmd.Flags().Int64Var(&sendAmount, "amount", 0, "Amount to send")
+	SendCmd.Flags().StringVar(&sendAmount, "amount", "", "Amount to send, such as 7.5")
 	SendCmd.Flags().BoolVar(&sendMine, "mine", false, "")
 	SendCmd.MarkFlagRequired("from")
 	SendCmd.MarkFlagRequired("to")
@@ -39,12 +39,16 @@ var SendCmd = &cobra.Command{
 			log.Errln("Recipient address is not valid")
 		}
 
+		amount := parseAmount(sendAmount)
 		network.Register()
 		if sendMine {
+			if w, ok := getWallets()[global.Address]; ok && w.WatchOnly && !wallet.HasExternalSigner() {
+				log.Errln(wallet.ErrWatchOnly)
+			}
 			bc := core.GetBlockchain(global.GetGroup())
 			set := core.GetUTXOSet(global.GetGroup())
 
-			tx, err := set.CreateTransaction(global.Address, sendTo, sendAmount)
+			tx, err := set.CreateTransaction(global.Address, sendTo, amount)
 			log.Err(err)
 			cbTx := core.NewCoinbaseTxn(global.Address)
 			txs := []*types.Transaction{cbTx, tx}
@@ -55,10 +59,12 @@ var SendCmd = &cobra.Command{
 			set.Update(newBlocks[0])
 			return
 		}
-		err := api.SendCMD(global.Address, sendTo, sendAmount)
+		raw, err := api.SendCMD(network.Context(), global.Address, sendTo, amount)
 
 		if err != nil {
 			log.Warnln(err)
+		} else if raw != "" {
+			log.Infof("Unsigned transaction: %s\n", raw)
 		} else {
 			log.Infoln("Success!")
 		}
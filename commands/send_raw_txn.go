@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var sendRaw string
+
+func init() {
+	SendRawTxnCmd.Flags().StringVar(&sendRaw, "raw", "", "Signed raw transaction to broadcast")
+	SendRawTxnCmd.MarkFlagRequired("raw")
+}
+
+var SendRawTxnCmd = &cobra.Command{
+	Use:   "send_raw_txn",
+	Short: "Broadcast a signed raw transaction through the local node",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		hash, err := api.SendRawTxn(network.Context(), sendRaw)
+
+		if err != nil {
+			log.Warnln(err)
+		} else {
+			log.Infof("Success! Hash: %s\n", hash)
+		}
+	},
+}
@@ -47,7 +47,7 @@ var SendTestCmd = &cobra.Command{
 				sendTestTo := string(wallet.NewWallet().GetAddress())
 				log.Infoln("SendTest", mempool.GetMempoolSize(group),
 					global.Address, sendTestTo)
-				err := api.SendCMD(global.Address, sendTestTo, 1)
+				_, err := api.SendCMD(network.Context(), global.Address, sendTestTo, 1)
 
 				if err != nil {
 					log.Warnln("SendTest Warn?", err)
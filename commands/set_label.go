@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var (
+	labelKey   string
+	labelValue string
+)
+
+func init() {
+	SetLabelCmd.Flags().StringVar(&labelKey, "key", "", "Address or transaction hash")
+	SetLabelCmd.Flags().StringVar(&labelValue, "label", "", "Label, empty to remove")
+	SetLabelCmd.MarkFlagRequired("key")
+}
+
+var SetLabelCmd = &cobra.Command{
+	Use:   "set_label",
+	Short: "Sets a label on an address or a transaction in the wallet",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			getWallets()
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		log.Err(api.SetLabel(network.Context(), labelKey, labelValue))
+		log.Infof("Label of '%s': %s\n", labelKey, labelValue)
+	},
+}
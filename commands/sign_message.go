@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var (
+	messageAddress string
+	message        string
+)
+
+func init() {
+	SignMessageCmd.Flags().StringVar(&messageAddress, "address", "", "Address whose private key signs the message")
+	SignMessageCmd.Flags().StringVar(&message, "message", "", "Message to sign")
+	SignMessageCmd.MarkFlagRequired("address")
+	SignMessageCmd.MarkFlagRequired("message")
+}
+
+var SignMessageCmd = &cobra.Command{
+	Use:   "sign_message",
+	Short: "Signs a message with the private key of an address to prove ownership",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			getWallets()
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		signature, err := api.SignMessage(network.Context(), messageAddress, message)
+		log.Err(err)
+		log.Infof("Signature: %s\n", signature)
+	},
+}
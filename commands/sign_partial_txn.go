@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var (
+	signPartialIn  string
+	signPartialOut string
+	signPartialYes bool
+)
+
+func init() {
+	SignPartialTxnCmd.Flags().StringVar(&signPartialIn, "in", "", "Partial transaction file to sign")
+	SignPartialTxnCmd.Flags().StringVar(&signPartialOut, "out", "", "File to write, default overwrite IN")
+	SignPartialTxnCmd.Flags().BoolVar(&signPartialYes, "yes", false, "Sign without asking for confirmation")
+	SignPartialTxnCmd.MarkFlagRequired("in")
+}
+
+var SignPartialTxnCmd = &cobra.Command{
+	Use:   "sign_partial_txn",
+	Short: "Sign the inputs of a partial transaction file owned by this wallet",
+	Run: func(cmd *cobra.Command, args []string) {
+		partial := readPartialTxn(signPartialIn)
+		for key, value := range partial.Metadata {
+			log.Infof("Metadata %s: %s\n", key, value)
+		}
+
+		wallets := getWallets()
+		if wallet.IsLocked() {
+			log.Errln(wallet.ErrWalletLocked, "use --unlock to unlock it")
+		}
+
+		// 签名前显示每个输出和手续费，输入金额在签名的数据中，必须和引用的输出一致
+		txn := partial.Txn
+		log.Err(txn.CheckValues())
+		prevOuts := make([]*types.TxnOutput, len(partial.PrevOuts))
+		var sumIn, sumOut types.Amount
+		for i := range partial.PrevOuts {
+			prevOuts[i] = &partial.PrevOuts[i]
+			sumIn += partial.PrevOuts[i].Value
+		}
+		log.Err(txn.CheckInputs(prevOuts))
+		for i, out := range txn.Vout {
+			address := wallet.PubKeyHashToAddress(out.PubKeyHash)
+			if _, ok := wallets.GetWallet(address); ok {
+				address += " (own)"
+			}
+			log.Infof("Output %d: %s to %s\n", i, out.Value, address)
+			sumOut += out.Value
+		}
+		log.Infof("Fee: %s\n", sumIn-sumOut)
+		if !signPartialYes && !readConfirmation("Sign this transaction? [y/N] ") {
+			log.Errln("Not signed")
+		}
+
+		signed := 0
+		for address := range wallets {
+			signer, err := wallets.GetSigner(address)
+			if err != nil {
+				// 只读地址没有私钥，或外部签名进程没有它的私钥
+				if err != wallet.ErrWatchOnly {
+					log.Warn(err)
+				}
+				continue
+			}
+			n, err := partial.Sign(signer)
+			log.Err(err)
+			signed += n
+		}
+
+		if signed == 0 {
+			log.Errln("No input can be signed by this wallet")
+		}
+
+		if signPartialOut == "" {
+			signPartialOut = signPartialIn
+		}
+		writePartialTxn(signPartialOut, partial)
+	},
+}
@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var signRaw string
+
+func init() {
+	SignRawTxnCmd.Flags().StringVar(&signRaw, "raw", "", "Raw transaction to sign")
+	SignRawTxnCmd.MarkFlagRequired("raw")
+}
+
+var SignRawTxnCmd = &cobra.Command{
+	Use:   "sign_raw_txn",
+	Short: "Sign a raw transaction with the wallet file, without network access",
+	Run: func(cmd *cobra.Command, args []string) {
+		raw, err := types.DecodeRawTxn(signRaw)
+		log.Err(err)
+
+		wallets := getWallets()
+		if wallet.IsLocked() {
+			log.Errln(wallet.ErrWalletLocked, "use --unlock to unlock it")
+		}
+
+		signed := 0
+		for address := range wallets {
+			signer, err := wallets.GetSigner(address)
+			if err != nil {
+				// 只读地址没有私钥，或外部签名进程没有它的私钥
+				if err != wallet.ErrWatchOnly {
+					log.Warn(err)
+				}
+				continue
+			}
+			n, err := raw.Sign(signer)
+			log.Err(err)
+			signed += n
+		}
+
+		if signed == 0 {
+			log.Errln("No input can be signed by this wallet")
+		}
+		log.Infof("Signed %d/%d inputs, complete: %t\n", signed, len(raw.Txn.Vin), raw.IsComplete())
+		log.Infof("Raw transaction: %s\n", raw.Encode())
+	},
+}
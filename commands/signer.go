@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"net"
+	"os"
+
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var signerSocket string
+
+func init() {
+	SignerCmd.Flags().StringVar(&signerSocket, "socket", "",
+		"Unix socket to listen on, default is serving on stdin and stdout")
+}
+
+var SignerCmd = &cobra.Command{
+	Use:   "signer",
+	Short: "Runs an external signer process serving the wallet's keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		wallets := getWallets()
+		if wallet.IsLocked() {
+			log.Errln(wallet.ErrWalletLocked, "use --unlock to unlock it")
+		}
+
+		if signerSocket == "" {
+			log.Err(wallet.ServeSigner(wallets, os.Stdin, os.Stdout))
+			return
+		}
+
+		os.Remove(signerSocket)
+		listener, err := net.Listen("unix", signerSocket)
+		log.Err(err)
+		log.Err(os.Chmod(signerSocket, 0600))
+		log.Infof("Signer listening on %s\n", signerSocket)
+		for {
+			conn, err := listener.Accept()
+			log.Err(err)
+			go func() {
+				defer conn.Close()
+				log.Warn(wallet.ServeSigner(wallets, conn, conn))
+			}()
+		}
+	},
+}
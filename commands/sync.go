@@ -4,6 +4,7 @@ import (
 	"github.com/YouDad/blockchain/api"
 	"github.com/YouDad/blockchain/global"
 	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
 	"github.com/spf13/cobra"
 )
 
@@ -19,7 +20,7 @@ var SyncCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Debugln("Syncing", global.Port)
 		for i := 0; i < global.GroupNum; i++ {
-			api.Sync(global.GetGroup() + i)
+			log.Warn(api.Sync(network.Context(), global.GetGroup()+i))
 		}
 	},
 }
@@ -0,0 +1,30 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var unbanNode string
+
+func init() {
+	UnbanCmd.Flags().StringVar(&unbanNode, "node", "", "Node ID or IP of the peer to unban, or its address when it was banned")
+	UnbanCmd.MarkFlagRequired("node")
+}
+
+var UnbanCmd = &cobra.Command{
+	Use:   "unban",
+	Short: "Lifts the ban on a peer and resets its misbehavior score",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		if api.HeartBeat(network.Context()) != nil {
+			go network.StartServer(api.Sync)
+			<-network.ServerReady
+		}
+
+		log.Err(api.Unban(network.Context(), unbanNode))
+		log.Infof("Unbanned: %s\n", unbanNode)
+	},
+}
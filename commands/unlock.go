@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/network"
+	"github.com/spf13/cobra"
+)
+
+var unlockTimeout int64
+
+func init() {
+	UnlockCmd.Flags().Int64Var(&unlockTimeout, "timeout", 60, "Seconds before the wallet is locked again")
+}
+
+var UnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Unlock the wallet of the running node for TIMEOUT seconds, the passphrase is read from the terminal",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		err := api.UnlockWallet(network.Context(), readPassphrase("Passphrase: "), unlockTimeout)
+
+		if err != nil {
+			log.Warnln(err)
+		} else {
+			log.Infof("Wallet unlocked for %d seconds\n", unlockTimeout)
+		}
+	},
+}
+
+var LockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Lock the wallet of the running node",
+	Run: func(cmd *cobra.Command, args []string) {
+		network.Register()
+		err := api.LockWallet(network.Context())
+
+		if err != nil {
+			log.Warnln(err)
+		} else {
+			log.Infoln("Wallet locked")
+		}
+	},
+}
@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/wallet"
+	"github.com/spf13/cobra"
+)
+
+var messageSignature string
+
+func init() {
+	VerifyMessageCmd.Flags().StringVar(&messageAddress, "address", "", "Address that signed the message")
+	VerifyMessageCmd.Flags().StringVar(&messageSignature, "signature", "", "Signature printed by sign_message")
+	VerifyMessageCmd.Flags().StringVar(&message, "message", "", "Message that was signed")
+	VerifyMessageCmd.MarkFlagRequired("address")
+	VerifyMessageCmd.MarkFlagRequired("signature")
+	VerifyMessageCmd.MarkFlagRequired("message")
+}
+
+var VerifyMessageCmd = &cobra.Command{
+	Use:   "verify_message",
+	Short: "Verifies a message signature against an address, without network access",
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Err(wallet.VerifyMessage(messageAddress, messageSignature, message))
+		log.Infoln("Signature is valid")
+	},
+}
@@ -91,6 +91,24 @@ func (iter *BlockchainIterator) Next() (nextBlock *types.Block) {
 	return nextBlock
 }
 
+// 清空本组的区块、区块头、交易、UTXO和钱包记录，高度缓存一起重置
+func (bc *Blockchain) Clear() {
+	bc.blockClear()
+	bc.txn.Clear(bc.group)
+	GetBlockhead(bc.group).clear()
+	GetUTXOSet(bc.group).clear()
+	GetWalletHistory(bc.group).clear()
+
+	bc.GetHeight()
+	mutexHeight.Lock()
+	cacheHeight[bc.group] = -1
+	mutexHeight.Unlock()
+	GetBlockhead(bc.group).GetHeight()
+	mutexBlockheadHeight.Lock()
+	cacheBlockheadHeight[bc.group] = -1
+	mutexBlockheadHeight.Unlock()
+}
+
 func CreateBlockchain(minerAddress string) error {
 	group := global.GetGroup()
 	bc := GetBlockchain(group)
@@ -98,7 +116,7 @@ func CreateBlockchain(minerAddress string) error {
 	if err != nil {
 		return err
 	}
-	bc.blockClear()
+	bc.Clear()
 	bc.AddBlock(block)
 	GetUTXOSet(group).Reindex()
 	bc.TxnReindex()
@@ -248,6 +266,48 @@ func (bc *Blockchain) DeleteBlock(b *types.Block) {
 	}
 }
 
+// 把分叉点fork之后的区块换成blocks，区块头也一起换掉，
+// blocks中有无效的交易时撤销已经加上的区块，恢复原来的分支
+func (bc *Blockchain) SwitchBranch(fork *types.Block, blocks []*types.Block) error {
+	set := GetUTXOSet(bc.group)
+	var old []*types.Block
+	for i := fork.Height + 1; i <= bc.GetHeight(); i++ {
+		block := bc.GetBlockByHeight(i)
+		if block == nil {
+			log.Warnln("[FAIL] SwitchBranch GetBlockByHeight return nil", i)
+			break
+		}
+		old = append(old, block)
+	}
+	bc.rollback(set, fork, old)
+
+	for i, block := range blocks {
+		// 区块和区块头一致，交易无效说明提供区块头的节点给了无效的链
+		if err := bc.VerifyBlockTxns(block); err != nil {
+			bc.rollback(set, fork, blocks[:i])
+			for _, block := range old {
+				bc.AddBlock(block)
+				set.Update(block)
+			}
+			log.Warn(GetBlockhead(bc.group).AddHeaders(append([]*types.Block{fork}, old...)))
+			return err
+		}
+
+		bc.AddBlock(block)
+		set.Update(block)
+	}
+	return GetBlockhead(bc.group).AddHeaders(append([]*types.Block{fork}, blocks...))
+}
+
+// 从最高的区块开始撤销分叉点之后的区块和UTXOSet
+func (bc *Blockchain) rollback(set *UTXOSet, fork *types.Block, blocks []*types.Block) {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		set.Reverse(blocks[i])
+		bc.DeleteBlock(blocks[i])
+	}
+	bc.SetLastest(fork)
+}
+
 func MineBlocksForCreate(txn *types.Transaction, groupBase int) (*types.Block, error) {
 	txns := []*types.Transaction{txn}
 	blocks := []*types.Block{{
@@ -392,6 +452,45 @@ func (bc *Blockchain) FindUTXO() map[string][]types.TxnOutput {
 	return utxos
 }
 
+var (
+	cacheUsedTip = make(map[int]string)
+	cacheUsed    = make(map[int]map[string]bool)
+	mutexUsed    sync.Mutex
+)
+
+// 找出链上所有输出使用过的公钥哈希，结果按链尾缓存，链尾不变时不再扫描，
+// 同一时间只有一个扫描，返回的map不能修改
+func (bc *Blockchain) FindUsedPubKeyHashes() map[string]bool {
+	lastest := bc.GetLastest()
+	if lastest == nil {
+		return make(map[string]bool)
+	}
+
+	mutexUsed.Lock()
+	defer mutexUsed.Unlock()
+	tip := lastest.Hash().String()
+	if cacheUsedTip[bc.group] == tip {
+		return cacheUsed[bc.group]
+	}
+
+	used := make(map[string]bool)
+	iter := &BlockchainIterator{bc, lastest.Hash()}
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		for _, txn := range block.Txns {
+			for _, out := range txn.Vout {
+				used[out.PubKeyHash.String()] = true
+			}
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+	cacheUsedTip[bc.group] = tip
+	cacheUsed[bc.group] = used
+	return used
+}
+
 func (bc *Blockchain) FindTxn(hash types.HashValue) (*types.Transaction, error) {
 	b := bc.txnGet(hash)
 	if len(b) == 0 {
@@ -400,7 +499,7 @@ func (bc *Blockchain) FindTxn(hash types.HashValue) (*types.Transaction, error)
 	return BytesToTransaction(b), nil
 }
 
-func (bc *Blockchain) SignTransaction(txn *types.Transaction, sk types.PrivateKey) error {
+func (bc *Blockchain) SignTransaction(txn *types.Transaction, signer types.Signer) error {
 	hashedTxn := make(map[string]types.Transaction)
 
 	for _, vin := range txn.Vin {
@@ -417,38 +516,32 @@ func (bc *Blockchain) SignTransaction(txn *types.Transaction, sk types.PrivateKe
 		hashedTxn[vinTxn.Hash().String()] = *vinTxn
 	}
 
-	return txn.Sign(sk, hashedTxn)
+	return txn.Sign(signer, hashedTxn)
 }
 
 // 验证交易是否有效
 func (bc *Blockchain) VerifyTransaction(txn types.Transaction) error {
+	if err := txn.CheckValues(); err != nil {
+		return err
+	}
+
 	if txn.IsCoinbase() {
 		return nil
 	}
 
-	// map[前置交易哈希]前置交易
-	prevTxns := make(map[string]types.Transaction)
-
-	// 遍历交易的输入
-	for _, vin := range txn.Vin {
-		// 在区块链中用引用交易哈希找到该输入引用的交易
-		prevTxn, err := bc.FindTxn(vin.VoutHash)
-		if err == nil {
-			prevTxns[prevTxn.Hash().String()] = *prevTxn
-			continue
-		}
-
-		// 在未打包交易池中用引用交易哈希找到该输入引用的交易
-		prevTxn, err = mempool.GetTxn(bc.group, vin.VoutHash)
-		if err != nil {
-			return errors.New("mempool not found " + vin.VoutHash.String())
-		}
-		prevTxns[prevTxn.Hash().String()] = *prevTxn
+	// 在区块链和未打包交易池中找到输入引用的输出，金额以它们为准
+	prevOuts, err := bc.FindPrevOuts(txn)
+	if err != nil {
+		return err
+	}
+	if err := txn.CheckInputs(prevOuts); err != nil {
+		return err
 	}
 
-	if txn.Verify(prevTxns) {
-		return nil
-	} else {
-		return errors.New("verify false")
+	for inIndex, prevOut := range prevOuts {
+		if !txn.VerifyInput(inIndex, *prevOut, types.DefaultSigCache) {
+			return errors.New("verify false")
+		}
 	}
+	return nil
 }
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/YouDad/blockchain/global"
@@ -94,3 +96,52 @@ func (bh *Blockhead) AddBlockhead(block *types.Block) bool {
 func (bh *Blockhead) GetBlockheadByHeight(height int32) *types.Block {
 	return BytesToBlock(bh.get(height))
 }
+
+var ErrHeaderChain = errors.New("Headers are not a valid chain")
+
+// 校验一段连续的区块头：工作量证明有效，并且一个接一个
+func VerifyHeaders(headers []*types.Block) error {
+	for i, header := range headers {
+		if !header.Verify() {
+			return fmt.Errorf("Header %d %s: proof of work is invalid", header.Height, header.Hash())
+		}
+		if i != 0 && (header.Height != headers[i-1].Height+1 ||
+			!header.PrevHash.Equal(headers[i-1].Hash())) {
+			return ErrHeaderChain
+		}
+	}
+	return nil
+}
+
+// 校验一段连续的区块头并保存，高度不高于已有区块头时覆盖分叉后的部分
+// 第一个区块头和已有区块的连接由调用者检查
+func (bh *Blockhead) AddHeaders(headers []*types.Block) error {
+	if err := VerifyHeaders(headers); err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	oldHeight := bh.GetHeight()
+	var bytes []byte
+	for _, header := range headers {
+		txns := header.Txns
+		header.Txns = nil
+		bytes = utils.Encode(header)
+		header.Txns = txns
+		bh.set(header.Height, bytes)
+	}
+
+	// 删除分叉链上更高的区块头
+	height := headers[len(headers)-1].Height
+	for i := height + 1; i <= oldHeight; i++ {
+		bh.Delete(i)
+	}
+
+	mutexBlockheadHeight.Lock()
+	cacheBlockheadHeight[bh.group] = height
+	mutexBlockheadHeight.Unlock()
+	bh.set("lastest", bytes)
+	return nil
+}
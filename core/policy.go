@@ -0,0 +1,117 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/utils"
+)
+
+// 交易转发策略，和共识规则无关，只决定本节点是否接收和转发一笔交易
+type Policy struct {
+	DustLimit     types.Amount // 单个输出的最小金额
+	MaxTxnSize    int          // 交易编码后的最大字节数
+	MaxTxnInputs  int          // 单笔交易的最大输入数
+	MaxTxnOutputs int          // 单笔交易的最大输出数
+}
+
+var RelayPolicy = Policy{
+	DustLimit:     1,
+	MaxTxnSize:    100_000,
+	MaxTxnInputs:  250,
+	MaxTxnOutputs: 250,
+}
+
+var (
+	ErrNegativeValue = errors.New("transaction has negative value")
+	ErrValueOverflow = errors.New("transaction value overflow")
+	ErrNotStandard   = errors.New("transaction is not standard")
+)
+
+// 检查交易是否满足本节点的转发策略，prevOuts[i]是第i个输入引用的输出，
+// 其他组转发来的交易不知道引用的输出，为nil
+func (p *Policy) CheckTransaction(txn types.Transaction, prevOuts []*types.TxnOutput) error {
+	if txn.IsCoinbase() {
+		return fmt.Errorf("%w: coinbase can't be relayed", ErrNotStandard)
+	}
+
+	if len(txn.Vin) == 0 || len(txn.Vout) == 0 {
+		return fmt.Errorf("%w: empty vin or vout", ErrNotStandard)
+	}
+
+	if len(txn.Vin) > p.MaxTxnInputs {
+		return fmt.Errorf("%w: %d inputs, max %d",
+			ErrNotStandard, len(txn.Vin), p.MaxTxnInputs)
+	}
+
+	if len(txn.Vout) > p.MaxTxnOutputs {
+		return fmt.Errorf("%w: %d outputs, max %d",
+			ErrNotStandard, len(txn.Vout), p.MaxTxnOutputs)
+	}
+
+	if size := len(utils.Encode(txn)); size > p.MaxTxnSize {
+		return fmt.Errorf("%w: size %d, max %d", ErrNotStandard, size, p.MaxTxnSize)
+	}
+
+	// 遍历交易的输入，金额用引用的输出的金额，不相信交易中记录的
+	var sumIn types.Amount
+	known := true
+	spent := make(map[string]bool)
+	for i, vin := range txn.Vin {
+		if vin.VoutValue < 0 {
+			return ErrNegativeValue
+		}
+
+		if prevOuts[i] == nil {
+			known = false
+		} else if prevOuts[i].Value != vin.VoutValue {
+			return fmt.Errorf("%w: %v", ErrNotStandard, types.ErrInputValue)
+		} else {
+			var err error
+			if sumIn, err = sumIn.Add(prevOuts[i].Value); err != nil {
+				return ErrValueOverflow
+			}
+		}
+
+		if vin.VoutIndex < 0 || len(vin.PubKey) == 0 || len(vin.PubKey) > 64 ||
+			len(vin.Signature) == 0 || len(vin.Signature) > 64 {
+			return fmt.Errorf("%w: malformed input", ErrNotStandard)
+		}
+
+		// 同一笔交易不能重复引用同一个输出
+		key := fmt.Sprintf("%s:%d", vin.VoutHash, vin.VoutIndex)
+		if spent[key] {
+			return fmt.Errorf("%w: duplicate input %s", ErrNotStandard, key)
+		}
+		spent[key] = true
+	}
+
+	// 遍历交易的输出
+	var sumOut types.Amount
+	for _, vout := range txn.Vout {
+		if vout.Value < 0 {
+			return ErrNegativeValue
+		}
+
+		if vout.Value < p.DustLimit {
+			return fmt.Errorf("%w: dust output %s, min %s",
+				ErrNotStandard, vout.Value, p.DustLimit)
+		}
+
+		var err error
+		if sumOut, err = sumOut.Add(vout.Value); err != nil {
+			return ErrValueOverflow
+		}
+
+		if len(vout.PubKeyHash) != 20 {
+			return fmt.Errorf("%w: malformed output", ErrNotStandard)
+		}
+	}
+
+	if known && sumOut > sumIn {
+		return fmt.Errorf("%w: outputs %s exceed inputs %s", ErrNotStandard, sumOut, sumIn)
+	}
+
+	return nil
+}
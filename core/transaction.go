@@ -9,6 +9,9 @@ import (
 	"github.com/YouDad/blockchain/utils"
 )
 
+// 挖矿奖励
+const Subsidy = 50 * types.Coin
+
 func NewCoinbaseTxn(from string) *types.Transaction {
 	randData := make([]byte, 32)
 	rand.Seed(time.Now().UnixNano())
@@ -18,9 +21,9 @@ func NewCoinbaseTxn(from string) *types.Transaction {
 
 	txn := types.Transaction{}
 
-	txn.Vin = []types.TxnInput{{VoutIndex: -1, VoutValue: 50_000_000, PubKey: randData}}
+	txn.Vin = []types.TxnInput{{VoutIndex: -1, VoutValue: Subsidy, PubKey: randData}}
 	// Send $from 50BTC
-	txn.Vout = []types.TxnOutput{*NewTxnOutput(from, 50_000_000)}
+	txn.Vout = []types.TxnOutput{*NewTxnOutput(from, Subsidy)}
 	return &txn
 }
 
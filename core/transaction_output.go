@@ -6,7 +6,7 @@ import (
 	"github.com/YouDad/blockchain/utils"
 )
 
-func NewTxnOutput(address string, value int64) *types.TxnOutput {
+func NewTxnOutput(address string, value types.Amount) *types.TxnOutput {
 	pubKeyHash := utils.Base58Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
 
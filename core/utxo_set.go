@@ -82,6 +82,7 @@ func (set *UTXOSet) Update(b *types.Block) {
 		set.set(txn.Hash(), utils.Encode(newOutputs))
 	}
 	global.UpdateUnlock()
+	GetWalletHistory(set.group).Update(b)
 }
 
 func (set *UTXOSet) Reverse(b *types.Block) {
@@ -89,8 +90,9 @@ func (set *UTXOSet) Reverse(b *types.Block) {
 	defer global.UpdateUnlock()
 	for i := range b.Txns {
 		txn := b.Txns[len(b.Txns)-i-1]
+		// 挖矿奖励的输出也要删除
+		set.delete(txn.Hash())
 		if !txn.IsCoinbase() {
-			set.delete(txn.Hash())
 			for _, vin := range txn.Vin {
 				var txos []types.TxnOutput
 				txosBytes := set.get(vin.VoutHash)
@@ -105,6 +107,7 @@ func (set *UTXOSet) Reverse(b *types.Block) {
 			}
 		}
 	}
+	GetWalletHistory(set.group).Reverse(b)
 }
 
 func (set *UTXOSet) Reindex() {
@@ -118,60 +121,141 @@ func (set *UTXOSet) Reindex() {
 		log.Err(err)
 		set.set(hash, utils.Encode(utxos))
 	}
+	GetWalletHistory(set.group).Reindex()
 }
 
 // 构造新的交易
-func (set *UTXOSet) CreateTransaction(from, to string, amount int64) (*types.Transaction, error) {
+func (set *UTXOSet) CreateTransaction(from, to string, amount types.Amount) (*types.Transaction, error) {
 	// 找到发送者的私钥
 	wallets, err := wallet.GetWallets()
 	if err != nil {
 		return nil, err
 	}
 
-	fromWallet, have := wallets[from]
+	fromWallet, have := wallets.GetWallet(from)
 	if !have {
 		return nil, errors.New(fmt.Sprintf("You haven't %s's PrivateKey", from))
 	}
 
-	// 用公钥找到一定数量的余额
-	sum, utxos, values := set.findUTXOs(fromWallet.PublicKey, amount)
-
-	if sum < amount {
-		return nil, errors.New("Not enough BTC")
+	// 只读地址没有私钥也没有外部签名进程时，返回未签名的交易，在别处签名
+	signer, err := wallets.GetSigner(from)
+	if err == wallet.ErrWatchOnly {
+		raw, err := set.CreateRawTransaction(from, to, amount)
+		if err != nil {
+			return nil, err
+		}
+		txn := raw.Txn
+		for i := range txn.Vin {
+			txn.Vin[i].PubKey = fromWallet.PublicKey
+		}
+		return &txn, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// 构造TxnInput
-	ins := []types.TxnInput{}
-	for txnHash, outIdxs := range utxos {
-		txnHashByte, err := hex.DecodeString(txnHash)
+	// 找零给新生成的同组地址，避免地址重用，
+	// 外部签名进程保管的地址找零给自己，私钥不离开签名进程，
+	// 确实有找零时才占用新地址，余额不足的请求不会消耗找零路径的索引
+	raw, err := set.createRawTransaction([]string{from}, to, from, amount)
+	if err != nil {
+		return nil, err
+	}
+	if !fromWallet.WatchOnly && len(raw.Txn.Vout) > 1 {
+		changeWallet, err := wallets.ReserveChangeWallet(set.group)
 		if err != nil {
 			return nil, err
 		}
+		raw.Txn.Vout[1] = *NewTxnOutput(changeWallet.String(), raw.Txn.Vout[1].Value)
+	}
+
+	// 交易签名
+	txn := raw.Txn
+	for i := range txn.Vin {
+		txn.Vin[i].PubKey = signer.PublicKey()
+	}
+	err = set.bc.SignTransaction(&txn, signer)
+	return &txn, err
+}
+
+// 构造未签名的交易，不需要发送者的私钥
+func (set *UTXOSet) CreateRawTransaction(from, to string, amount types.Amount) (*types.RawTxn, error) {
+	return set.CreateMultiRawTransaction([]string{from}, to, amount)
+}
+
+// 从多个发送者凑足金额构造未签名的交易，找零给第一个发送者
+func (set *UTXOSet) CreateMultiRawTransaction(froms []string, to string, amount types.Amount) (*types.RawTxn, error) {
+	if len(froms) == 0 {
+		return nil, errors.New("No sender")
+	}
+	return set.createRawTransaction(froms, to, froms[0], amount)
+}
+
+func (set *UTXOSet) createRawTransaction(froms []string, to, change string, amount types.Amount) (*types.RawTxn, error) {
+	if amount <= 0 || !amount.Valid() {
+		return nil, errors.New(fmt.Sprintf("Amount %s is out of range", amount))
+	}
+
+	ins := []types.TxnInput{}
+	prevOuts := []types.TxnOutput{}
+	var sum types.Amount
+	for _, from := range froms {
+		if global.GetGroupByAddress(from) != set.group {
+			return nil, errors.New(fmt.Sprintf("Sender %s is not in group %d", from, set.group))
+		}
+
+		// 用公钥哈希找到一定数量的余额
+		fromPubKeyHash := wallet.AddressToPubKeyHash(from)
+		fromSum, utxos, values := set.findUTXOs(fromPubKeyHash, amount-sum)
+
+		// 构造TxnInput
+		for txnHash, outIdxs := range utxos {
+			txnHashByte, err := hex.DecodeString(txnHash)
+			if err != nil {
+				return nil, err
+			}
+
+			for i, outIdx := range outIdxs {
+				ins = append(ins, types.TxnInput{
+					VoutHash:  txnHashByte,
+					VoutIndex: outIdx,
+					VoutValue: values[txnHash][i],
+					Signature: nil,
+					PubKey:    nil,
+				})
+				prevOuts = append(prevOuts, types.TxnOutput{
+					Value:      values[txnHash][i],
+					PubKeyHash: fromPubKeyHash,
+				})
+			}
+		}
 
-		for i, outIdx := range outIdxs {
-			ins = append(ins, types.TxnInput{
-				VoutHash:  txnHashByte,
-				VoutIndex: outIdx,
-				VoutValue: values[txnHash][i],
-				Signature: nil,
-				PubKey:    fromWallet.PublicKey,
-			})
+		var err error
+		if sum, err = sum.Add(fromSum); err != nil {
+			return nil, err
 		}
+		if sum >= amount {
+			break
+		}
+	}
+
+	if sum < amount {
+		return nil, errors.New("Not enough BTC")
 	}
 
 	// 构造TxnOutput
 	outs := []types.TxnOutput{*NewTxnOutput(to, amount)}
 	if sum > amount {
-		outs = append(outs, *NewTxnOutput(from, sum-amount))
+		outs = append(outs, *NewTxnOutput(change, sum-amount))
 	}
 
-	// 交易签名
-	txn := types.Transaction{Vin: ins, Vout: outs}
-	err = set.bc.SignTransaction(&txn, fromWallet.PrivateKey)
-	return &txn, err
+	return &types.RawTxn{
+		Txn:      types.Transaction{Vin: ins, Vout: outs},
+		PrevOuts: prevOuts,
+	}, nil
 }
 
-func (set *UTXOSet) FindUTXOByHash(pubKey types.PublicKey) []types.TxnOutput {
+func (set *UTXOSet) FindUTXOByHash(pubKeyHash types.HashValue) []types.TxnOutput {
 	utxos := []types.TxnOutput{}
 
 	global.UpdateLock()
@@ -180,7 +264,7 @@ func (set *UTXOSet) FindUTXOByHash(pubKey types.PublicKey) []types.TxnOutput {
 		outs := BytesToTxnOutputs(v)
 
 		for _, out := range outs {
-			if out.IsLockedWithKey(pubKey) {
+			if out.PubKeyHash.Equal(pubKeyHash) {
 				utxos = append(utxos, out)
 			}
 		}
@@ -190,11 +274,12 @@ func (set *UTXOSet) FindUTXOByHash(pubKey types.PublicKey) []types.TxnOutput {
 	return utxos
 }
 
-// 用公钥找一定数量余额
-func (set *UTXOSet) findUTXOs(pubKey types.PublicKey, amount int64) (int64, map[string][]int, map[string][]int64) {
+// 用公钥哈希找一定数量余额
+func (set *UTXOSet) findUTXOs(pubKeyHash types.HashValue, amount types.Amount) (
+	types.Amount, map[string][]int, map[string][]types.Amount) {
 	hashedUTXOIdxs := make(map[string][]int)
-	hashedUTXOValues := make(map[string][]int64)
-	var sum int64 = 0
+	hashedUTXOValues := make(map[string][]types.Amount)
+	var sum types.Amount = 0
 
 	global.UpdateLock()
 	defer global.UpdateUnlock()
@@ -202,13 +287,18 @@ func (set *UTXOSet) findUTXOs(pubKey types.PublicKey, amount int64) (int64, map[
 		txnOutputs := BytesToTxnOutputs(v)
 
 		for txnOutputIndex, txnOutput := range txnOutputs {
-			if txnOutput.IsLockedWithKey(pubKey) {
+			if txnOutput.PubKeyHash.Equal(pubKeyHash) {
 				outs, hashs, indexs := mempool.ExpandTxnOutput(set.group, txnOutput, k, txnOutputIndex)
 
 				for i := range outs {
 					str := hashs[i].String()
 
-					sum += outs[i].Value
+					newSum, err := sum.Add(outs[i].Value)
+					if err != nil {
+						log.Warn(err)
+						return false
+					}
+					sum = newSum
 					hashedUTXOIdxs[str] = append(hashedUTXOIdxs[str], indexs[i])
 					hashedUTXOValues[str] = append(hashedUTXOValues[str], outs[i].Value)
 
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/global/mempool"
+	"github.com/YouDad/blockchain/types"
+)
+
+// 并行验证签名的协程数
+var VerifyWorkers = runtime.NumCPU()
+
+// 交易的输入引用的输出，在区块链和交易池中找
+func (bc *Blockchain) FindPrevOuts(txn types.Transaction) ([]*types.TxnOutput, error) {
+	prevOuts := make([]*types.TxnOutput, len(txn.Vin))
+	for i, vin := range txn.Vin {
+		prevTxn, err := bc.FindTxn(vin.VoutHash)
+		if err != nil {
+			prevTxn, err = mempool.GetTxn(bc.group, vin.VoutHash)
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Transaction is not found, %s", vin.VoutHash))
+		}
+		if vin.VoutIndex < 0 || len(prevTxn.Vout) <= vin.VoutIndex {
+			return nil, errors.New(fmt.Sprintf("VoutIndex out of range, %s", txn.Hash()))
+		}
+		prevOuts[i] = &prevTxn.Vout[vin.VoutIndex]
+	}
+	return prevOuts, nil
+}
+
+// 检查区块中所有交易的金额，并行验证所有交易输入的签名
+func (bc *Blockchain) VerifyBlockTxns(block *types.Block) error {
+	// 区块内的交易可以引用同一区块中前面的交易
+	blockTxns := make(map[string]*types.Transaction)
+	for _, txn := range block.Txns {
+		blockTxns[txn.Hash().String()] = txn
+	}
+
+	var jobs []types.InputJob
+	for _, txn := range block.Txns {
+		if err := txn.CheckValues(); err != nil {
+			return errors.New(fmt.Sprintf(
+				"Block %s txn %s: %s", block.Hash(), txn.Hash(), err))
+		}
+
+		if txn.IsCoinbase() {
+			var reward types.Amount
+			for _, out := range txn.Vout {
+				reward += out.Value
+			}
+			if reward > Subsidy {
+				return errors.New(fmt.Sprintf(
+					"Block %s coinbase %s exceeds subsidy", block.Hash(), reward))
+			}
+			continue
+		}
+
+		prevOuts := make([]*types.TxnOutput, len(txn.Vin))
+		for inIndex, vin := range txn.Vin {
+			// 其他组转发过来的交易，由转发时的默克尔路径保证有效
+			if bc.group != global.GetGroupByPubKeyHash(vin.PubKey.Hash()) {
+				continue
+			}
+
+			prevTxn, ok := blockTxns[vin.VoutHash.String()]
+			if !ok {
+				var err error
+				prevTxn, err = bc.FindTxn(vin.VoutHash)
+				if err != nil {
+					prevTxn, err = mempool.GetTxn(bc.group, vin.VoutHash)
+				}
+				if err != nil {
+					return errors.New(fmt.Sprintf(
+						"Block %s prevTxn not found, %s", block.Hash(), vin.VoutHash))
+				}
+			}
+
+			if vin.VoutIndex < 0 || len(prevTxn.Vout) <= vin.VoutIndex {
+				return errors.New(fmt.Sprintf(
+					"Block %s VoutIndex out of range, %s", block.Hash(), txn.Hash()))
+			}
+
+			prevOuts[inIndex] = &prevTxn.Vout[vin.VoutIndex]
+			jobs = append(jobs, types.InputJob{
+				Txn:     txn,
+				Index:   inIndex,
+				PrevOut: prevTxn.Vout[vin.VoutIndex],
+			})
+		}
+
+		// 金额以引用的输出为准，不相信交易中记录的
+		if err := txn.CheckInputs(prevOuts); err != nil {
+			return errors.New(fmt.Sprintf(
+				"Block %s txn %s: %s", block.Hash(), txn.Hash(), err))
+		}
+	}
+
+	if !types.VerifyInputs(jobs, VerifyWorkers, types.DefaultSigCache) {
+		return errors.New(fmt.Sprintf("Block %s signature verify false", block.Hash()))
+	}
+	return nil
+}
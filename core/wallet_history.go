@@ -0,0 +1,195 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/utils"
+	"github.com/YouDad/blockchain/wallet"
+)
+
+// 钱包地址的交易记录，随区块的连接和断开更新
+type WalletHistory struct {
+	db    *global.WalletTxnsDB
+	bc    *Blockchain
+	group int
+}
+
+func (h *WalletHistory) clear() {
+	h.db.Clear(h.group)
+}
+
+func (h *WalletHistory) set(key interface{}, value []byte) {
+	h.db.Set(h.group, key, value)
+}
+
+func (h *WalletHistory) delete(key interface{}) {
+	h.db.Delete(h.group, key)
+}
+
+func (h *WalletHistory) foreach(fn func(k, v []byte) bool) {
+	h.db.Foreach(h.group, fn)
+}
+
+func GetWalletHistory(group int) *WalletHistory {
+	return &WalletHistory{global.GetWalletTxnsDB(), GetBlockchain(group), group}
+}
+
+func walletTxnKey(wt types.WalletTxn) []byte {
+	return append(append([]byte{}, wt.Hash...), wt.Address...)
+}
+
+// 钱包中所有地址的公钥哈希，值表示是否为找零地址
+func walletPubKeyHashes() map[string]bool {
+	ours := make(map[string]bool)
+	wallets, err := wallet.GetWallets()
+	if err != nil {
+		log.Warn(err)
+		return ours
+	}
+
+	for address, w := range wallets {
+		ours[wallet.AddressToPubKeyHash(address).String()] = w.Internal
+	}
+	return ours
+}
+
+// 区块中和钱包地址有关的记录
+func (h *WalletHistory) walletTxns(b *types.Block, ours map[string]bool) []types.WalletTxn {
+	var wts []types.WalletTxn
+	for _, txn := range b.Txns {
+		var senders, receivers []string
+		amounts := make(map[string]int64)
+		isSender := make(map[string]bool)
+
+		if !txn.IsCoinbase() {
+			for _, vin := range txn.Vin {
+				pubKeyHash := vin.PubKey.Hash()
+				address := wallet.PubKeyHashToAddress(pubKeyHash)
+				senders = appendUnique(senders, address)
+
+				// 跨组转发的交易，支出记在发送方的组
+				if h.group != global.GetGroupByPubKeyHash(pubKeyHash) {
+					continue
+				}
+				if _, ok := ours[pubKeyHash.String()]; ok {
+					amounts[address] -= int64(vin.VoutValue)
+					isSender[address] = true
+				}
+			}
+		}
+
+		for _, out := range txn.Vout {
+			address := wallet.PubKeyHashToAddress(out.PubKeyHash)
+			// 自己的找零地址不算作对方
+			if !ours[out.PubKeyHash.String()] {
+				receivers = appendUnique(receivers, address)
+			}
+
+			// 跨组转发的交易，收入记在接收方的组
+			if h.group != global.GetGroupByPubKeyHash(out.PubKeyHash) {
+				continue
+			}
+			if _, ok := ours[out.PubKeyHash.String()]; ok {
+				amounts[address] += int64(out.Value)
+			}
+		}
+
+		for address, amount := range amounts {
+			counterparty := senders
+			if isSender[address] {
+				counterparty = receivers
+			}
+
+			wts = append(wts, types.WalletTxn{
+				Hash:         txn.Hash(),
+				Address:      address,
+				Amount:       types.Amount(amount),
+				Coinbase:     txn.IsCoinbase(),
+				Group:        h.group,
+				Height:       b.Height,
+				Timestamp:    b.Timestamp,
+				Counterparty: removeString(counterparty, address),
+			})
+		}
+	}
+	return wts
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func removeString(list []string, s string) []string {
+	var ret []string
+	for _, v := range list {
+		if v != s {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// 区块连接到链上时记录
+func (h *WalletHistory) Update(b *types.Block) {
+	for _, wt := range h.walletTxns(b, walletPubKeyHashes()) {
+		h.set(walletTxnKey(wt), utils.Encode(wt))
+	}
+}
+
+// 区块从链上断开时删除
+func (h *WalletHistory) Reverse(b *types.Block) {
+	for _, wt := range h.walletTxns(b, walletPubKeyHashes()) {
+		h.delete(walletTxnKey(wt))
+	}
+}
+
+// 扫描整条链重建记录
+func (h *WalletHistory) Reindex() {
+	h.clear()
+	if h.bc.GetLastest() == nil {
+		return
+	}
+
+	ours := walletPubKeyHashes()
+	iter := h.bc.Begin()
+	for block := iter.Next(); block != nil; block = iter.Next() {
+		for _, wt := range h.walletTxns(block, ours) {
+			h.set(walletTxnKey(wt), utils.Encode(wt))
+		}
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+}
+
+// 返回address的记录，address为空时返回所有记录，按高度从高到低排列
+func (h *WalletHistory) GetTxns(address string) []types.WalletTxn {
+	var wts []types.WalletTxn
+	height := h.bc.GetHeight()
+	h.foreach(func(k, v []byte) bool {
+		var wt types.WalletTxn
+		if err := utils.Decode(v, &wt); err != nil {
+			log.Warn(err)
+			return true
+		}
+		if address == "" || wt.Address == address {
+			wt.Confirmations = height - wt.Height + 1
+			wts = append(wts, wt)
+		}
+		return true
+	})
+
+	sort.SliceStable(wts, func(i, j int) bool {
+		return wts[i].Height > wts[j].Height
+	})
+	return wts
+}
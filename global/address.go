@@ -0,0 +1,96 @@
+package global
+
+import (
+	"net"
+	"sync"
+)
+
+var (
+	ExternalAddress string   // 告诉其他节点的地址，为空时使用握手中对方看到的地址
+	Listen          string   // 监听的地址，为空时监听所有网卡的Port端口
+	Seeds           []string // 启动时连接的节点
+	PeerEncryption  string   // 节点间连接是否加密：off、on或required，为空时看配置文件
+
+	mutexLearned   sync.Mutex
+	learnedAddress string
+	observedBy     = make(map[string]string) // 对方的IP -> 它看到的本节点IP
+)
+
+const (
+	learnQuorum  = 2  // 至少有这么多个不同IP的节点看到同一个IP才采用
+	maxObservers = 64 // 记住的节点个数
+)
+
+// 监听的地址
+func ListenAddress() string {
+	if Listen != "" {
+		return Listen
+	}
+	return "0.0.0.0:" + Port
+}
+
+// 其他节点可以连接到本节点的地址
+func SelfAddress() string {
+	if ExternalAddress != "" {
+		return ExternalAddress
+	}
+	mutexLearned.Lock()
+	defer mutexLearned.Unlock()
+	if learnedAddress != "" {
+		return learnedAddress
+	}
+	return "127.0.0.1:" + Port
+}
+
+// 握手时IP为peer的节点看到的本节点的IP，不是回环地址并且有learnQuorum个节点
+// 看到的都是它时作为本节点的地址，一个节点不能决定本节点告诉别人的地址
+func LearnAddress(peer, host string) {
+	ip := net.ParseIP(host)
+	if ExternalAddress != "" || peer == "" || ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+		return
+	}
+	mutexLearned.Lock()
+	defer mutexLearned.Unlock()
+	if _, ok := observedBy[peer]; !ok && len(observedBy) >= maxObservers {
+		for other := range observedBy {
+			delete(observedBy, other)
+			break
+		}
+	}
+	observedBy[peer] = ip.String()
+
+	// 票数相同时保留现在的地址
+	votes := make(map[string]int)
+	best, _, _ := net.SplitHostPort(learnedAddress)
+	for _, observed := range observedBy {
+		votes[observed]++
+	}
+	for observed, n := range votes {
+		if n > votes[best] {
+			best = observed
+		}
+	}
+	if votes[best] < learnQuorum {
+		return
+	}
+
+	_, port, err := net.SplitHostPort(ListenAddress())
+	if err != nil {
+		port = Port
+	}
+	learnedAddress = net.JoinHostPort(best, port)
+}
+
+// 忘掉从其他节点学到的地址，重新投票
+func ForgetLearnedAddress() {
+	mutexLearned.Lock()
+	defer mutexLearned.Unlock()
+	learnedAddress = ""
+	observedBy = make(map[string]string)
+}
+
+// address是否是本节点
+func IsSelf(address string) bool {
+	return address == SelfAddress() || address == "127.0.0.1:"+Port ||
+		address == "localhost:"+Port
+}
@@ -120,7 +120,11 @@ func (db *boltDB) Foreach(group int, fn func(k, v []byte) bool) {
 	log.Debugf("Foreach %s[%d]", db.currentBucket, group)
 	log.SetCallerLevel(0)
 	db.db(group).View(func(tx *bolt.Tx) error {
-		cursor := tx.Bucket([]byte(db.currentBucket)).Cursor()
+		bucket := tx.Bucket([]byte(db.currentBucket))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
 			if !fn(k, v) {
 				break
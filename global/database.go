@@ -3,6 +3,7 @@ package global
 import (
 	"encoding/hex"
 	"fmt"
+	"os"
 	"sync"
 
 	"github.com/YouDad/blockchain/log"
@@ -23,11 +24,16 @@ var (
 	instanceBoltDB = make(map[int]*bolt.DB)
 	onceBoltDB     = make(map[int]*sync.Once)
 	mutexBoltDB    = sync.Mutex{}
-	rootPath       = ""
 )
 
-func SetRootPath(path string) {
-	rootPath = path
+func databaseName(group int) string {
+	return DataPath(fmt.Sprintf("blockchain%s-%d.db", Port, group))
+}
+
+// 本地是否有该组的数据库文件
+func DatabaseExists(group int) bool {
+	_, err := os.Stat(databaseName(group))
+	return err == nil
 }
 
 func getDatabase(group int) *bolt.DB {
@@ -39,10 +45,9 @@ func getDatabase(group int) *bolt.DB {
 		once = onceBoltDB[group]
 	}
 	once.Do(func() {
-		databaseName := fmt.Sprintf("%sblockchain%s-%d.db", rootPath, Port, group)
-
+		log.Err(LockDataDir())
 		var err error
-		instanceBoltDB[group], err = bolt.Open(databaseName, 0600, nil)
+		instanceBoltDB[group], err = bolt.Open(databaseName(group), 0600, nil)
 		log.Err(err)
 	})
 	return instanceBoltDB[group]
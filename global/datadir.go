@@ -0,0 +1,51 @@
+package global
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var (
+	dataDir     = ""
+	onceLockDir sync.Once
+	errLockDir  error
+	lockFile    *os.File
+)
+
+// 设置数据目录，钱包、数据库和日志都放在这里，为空时使用当前目录
+func SetDataDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	dataDir = dir
+	return nil
+}
+
+// 数据目录中的文件路径
+func DataPath(name string) string {
+	return filepath.Join(dataDir, name)
+}
+
+// 锁定数据目录中本端口的文件，防止两个进程同时打开，锁在进程退出时释放
+func LockDataDir() error {
+	onceLockDir.Do(func() {
+		name := DataPath(fmt.Sprintf("blockchain%s.lock", Port))
+		lockFile, errLockDir = os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0600)
+		if errLockDir != nil {
+			return
+		}
+
+		err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			lockFile.Close()
+			errLockDir = fmt.Errorf("Data directory %s is in use by another process: %v",
+				filepath.Dir(name), err)
+		}
+	})
+	return errLockDir
+}
@@ -59,3 +59,17 @@ func GetTxnsDB() *TxnsDB {
 	})
 	return instanceTxnsDB
 }
+
+type WalletTxnsDB struct {
+	IDatabase
+}
+
+var instanceWalletTxnsDB *WalletTxnsDB
+var onceWalletTxnsDB sync.Once
+
+func GetWalletTxnsDB() *WalletTxnsDB {
+	onceWalletTxnsDB.Do(func() {
+		instanceWalletTxnsDB = &WalletTxnsDB{getBoltDB("WalletTxns")}
+	})
+	return instanceWalletTxnsDB
+}
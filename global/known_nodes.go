@@ -1,9 +1,13 @@
 package global
 
 import (
+	"io/ioutil"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/YouDad/blockchain/types"
+	jsoniter "github.com/json-iterator/go"
 	// "github.com/YouDad/blockchain/log"
 )
 
@@ -18,11 +22,57 @@ func GetKnownNodes() *KnownNodes {
 	return instance
 }
 
+// 连续失败maxNodeFailures次，或nodeExpiry内没有消息的节点被删除，种子节点除外
+const (
+	maxNodeFailures = 5
+	nodeExpiry      = time.Hour
+)
+
+// 已知节点表最多maxKnownNodes个节点，满了时删除排名最差的节点
+const maxKnownNodes = 1024
+
+// 心跳次数达到maxNodeAttempts时计数减半，成功率更多反映最近的情况
+const maxNodeAttempts = 20
+
 type NetworkNode struct {
 	ReactTime   types.Queue
-	Timestamp   int64
+	Timestamp   int64 // 最后一次听说这个节点的时间
 	GroupBase   int
 	GroupNumber int
+	LastSuccess int64  // 最后一次心跳成功的时间，从未成功时为0
+	Failures    int    // 心跳连续失败的次数
+	Attempts    int    // 最近心跳的次数
+	Successes   int    // 最近心跳成功的次数
+	ID          string // 握手验证过的节点ID，为空时还没有连接过
+	Seed        bool
+}
+
+// 心跳成功率，没有心跳过的节点按一半算
+func (node NetworkNode) SuccessRate() float64 {
+	return float64(node.Successes+1) / float64(node.Attempts+2)
+}
+
+// 平均响应时间，单位毫秒，没有测量过时返回false
+func (node NetworkNode) AverageReactTime() (int, bool) {
+	if node.ReactTime.Len() == 0 {
+		return 0, false
+	}
+	sum := 0
+	for _, rt := range node.ReactTime.Get() {
+		sum += rt.(int)
+	}
+	return sum / node.ReactTime.Len(), true
+}
+
+func (node *NetworkNode) attempt(success bool) {
+	if node.Attempts >= maxNodeAttempts {
+		node.Attempts /= 2
+		node.Successes /= 2
+	}
+	node.Attempts++
+	if success {
+		node.Successes++
+	}
 }
 
 type KnownNodes struct {
@@ -41,22 +91,227 @@ func (this *KnownNodes) Release() {
 	// log.Debugln("KN Unlock")
 }
 
-func (this *KnownNodes) AddNode(address string, time int64, groupBase int, groupNumber int) {
+// 节点的排名，心跳成功率低的更差，一样时最后有消息早的更差
+func (node NetworkNode) worseThan(other NetworkNode) bool {
+	if node.SuccessRate() != other.SuccessRate() {
+		return node.SuccessRate() < other.SuccessRate()
+	}
+	return node.lastHeard() < other.lastHeard()
+}
+
+func (node NetworkNode) lastHeard() int64 {
+	if node.LastSuccess > node.Timestamp {
+		return node.LastSuccess
+	}
+	return node.Timestamp
+}
+
+// 表满时删除排名最差的节点，种子节点除外，调用者持有锁
+func (this *KnownNodes) evict() {
+	for len(this.nodes) >= maxKnownNodes {
+		worst, found := "", false
+		for address, node := range this.nodes {
+			if !node.Seed && (!found || node.worseThan(this.nodes[worst])) {
+				worst, found = address, true
+			}
+		}
+		if !found {
+			return
+		}
+		delete(this.nodes, worst)
+	}
+}
+
+// 对方给的时间不能晚于现在，否则节点永远不会过期
+func clampTimestamp(timestamp int64) int64 {
+	if now := time.Now().UnixNano(); timestamp > now {
+		return now
+	}
+	return timestamp
+}
+
+// 加入节点，已知的节点更新时间和分组，返回是否是新节点
+func (this *KnownNodes) AddNode(address string, timestamp int64, groupBase int, groupNumber int) bool {
+	timestamp = clampTimestamp(timestamp)
 	knownNodes := this.Get()
 	defer this.Release()
-	_, ok := knownNodes[address]
+	node, ok := knownNodes[address]
 	if !ok {
-		knownNodes[address] = NetworkNode{types.NewQueue(5), time, groupBase, groupNumber}
+		this.evict()
+		knownNodes[address] = NetworkNode{ReactTime: types.NewQueue(5), Timestamp: timestamp,
+			GroupBase: groupBase, GroupNumber: groupNumber}
+		return true
+	}
+
+	if timestamp > node.Timestamp {
+		node.Timestamp = timestamp
+		node.GroupBase = groupBase
+		node.GroupNumber = groupNumber
+		knownNodes[address] = node
 	}
+	return false
 }
 
-func (this *KnownNodes) UpdateNode(address string, nano int64) {
+// 加入种子节点，种子节点不会被删除
+func (this *KnownNodes) AddSeed(address string, groupNumber int) {
+	this.AddNode(address, 0, 0, groupNumber)
 	knownNodes := this.Get()
 	defer this.Release()
 	node := knownNodes[address]
+	node.Seed = true
+	knownNodes[address] = node
+}
+
+// 心跳成功，记录响应时间
+func (this *KnownNodes) UpdateNode(address string, nano int64) {
+	knownNodes := this.Get()
+	defer this.Release()
+	node, ok := knownNodes[address]
+	if !ok {
+		return
+	}
 	if node.ReactTime.Len() == 5 {
 		node.ReactTime.Pop()
 	}
 	node.ReactTime.Push(int(nano / 1e6))
+	node.attempt(true)
+	node.LastSuccess = time.Now().UnixNano()
+	node.Failures = 0
+	knownNodes[address] = node
+}
+
+// 握手验证了address是节点id，同一个ID的其他地址被删除，种子节点除外，返回是否删除了节点
+func (this *KnownNodes) SetID(address, id string) bool {
+	knownNodes := this.Get()
+	defer this.Release()
+	node, ok := knownNodes[address]
+	if !ok || node.ID == id {
+		return false
+	}
+	node.ID = id
+	knownNodes[address] = node
+
+	removed := false
+	for other, node := range knownNodes {
+		if other != address && !node.Seed && node.ID == id {
+			delete(knownNodes, other)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// address验证过的节点ID
+func (this *KnownNodes) ID(address string) string {
+	knownNodes := this.Get()
+	defer this.Release()
+	return knownNodes[address].ID
+}
+
+// 节点id的地址，不知道时为空
+func (this *KnownNodes) AddressOf(id string) string {
+	knownNodes := this.Get()
+	defer this.Release()
+	for address, node := range knownNodes {
+		if node.ID == id {
+			return address
+		}
+	}
+	return ""
+}
+
+// 心跳失败，连续失败太多次时删除节点，返回是否删除
+func (this *KnownNodes) FailNode(address string) bool {
+	knownNodes := this.Get()
+	defer this.Release()
+	node, ok := knownNodes[address]
+	if !ok {
+		return false
+	}
+	node.Failures++
+	node.attempt(false)
+	if !node.Seed && node.Failures >= maxNodeFailures {
+		delete(knownNodes, address)
+		return true
+	}
 	knownNodes[address] = node
+	return false
+}
+
+// 删除很久没有消息的节点，返回删除的个数
+func (this *KnownNodes) Expire() int {
+	knownNodes := this.Get()
+	defer this.Release()
+	deadline := time.Now().Add(-nodeExpiry).UnixNano()
+	count := 0
+	for address, node := range knownNodes {
+		if !node.Seed && node.Timestamp < deadline && node.LastSuccess < deadline {
+			delete(knownNodes, address)
+			count++
+		}
+	}
+	return count
+}
+
+// 保存在文件中的节点，响应时间和种子标记不保存，节点ID保存下来，重启后仍然检查
+type savedNode struct {
+	Address     string
+	Timestamp   int64
+	GroupBase   int
+	GroupNumber int
+	LastSuccess int64
+	Failures    int
+	Attempts    int
+	Successes   int
+	ID          string
+}
+
+// 先写临时文件再改名
+func (this *KnownNodes) Save(filename string) error {
+	var nodes []savedNode
+	knownNodes := this.Get()
+	for address, node := range knownNodes {
+		nodes = append(nodes, savedNode{address, node.Timestamp, node.GroupBase,
+			node.GroupNumber, node.LastSuccess, node.Failures, node.Attempts, node.Successes, node.ID})
+	}
+	this.Release()
+
+	content, err := jsoniter.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filename+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// 读取保存的节点，文件不存在时什么也不做
+func (this *KnownNodes) Load(filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var nodes []savedNode
+	err = jsoniter.Unmarshal(content, &nodes)
+	if err != nil {
+		return err
+	}
+
+	knownNodes := this.Get()
+	defer this.Release()
+	for _, node := range nodes {
+		if _, ok := knownNodes[node.Address]; ok {
+			continue
+		}
+		this.evict()
+		knownNodes[node.Address] = NetworkNode{types.NewQueue(5), clampTimestamp(node.Timestamp), node.GroupBase,
+			node.GroupNumber, node.LastSuccess, node.Failures, node.Attempts, node.Successes, node.ID, false}
+	}
+	return nil
 }
@@ -2,6 +2,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
@@ -24,6 +25,16 @@ func Register(level uint, p string) {
 	port = p
 }
 
+// 日志同时追加写到文件中
+func SetOutputFile(name string) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, f))
+	return nil
+}
+
 func setPrefix(prefix string) {
 	_, file, line, _ := runtime.Caller(callLevel)
 	keyword := "blockchain"
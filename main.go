@@ -16,11 +16,35 @@ func main() {
 		cmd.GetVersionCmd,
 		cmd.ListAddressCmd,
 		cmd.CreateWalletCmd,
+		cmd.RestoreWalletCmd,
+		cmd.GetWalletBalanceCmd,
+		cmd.ListTransactionsCmd,
+		cmd.SetLabelCmd,
+		cmd.ImportAddressCmd,
+		cmd.ExportKeyCmd,
+		cmd.ImportKeyCmd,
+		cmd.SignMessageCmd,
+		cmd.VerifyMessageCmd,
+		cmd.SignerCmd,
+		cmd.ListBannedCmd,
+		cmd.UnbanCmd,
 		cmd.MiningCmd,
 		cmd.SyncCmd,
 		cmd.AllCmd,
 		cmd.SendTestCmd,
 		cmd.PrintCmd,
+		cmd.CreateRawTxnCmd,
+		cmd.SignRawTxnCmd,
+		cmd.DecodeRawTxnCmd,
+		cmd.SendRawTxnCmd,
+		cmd.CreatePartialTxnCmd,
+		cmd.SignPartialTxnCmd,
+		cmd.CombinePartialTxnCmd,
+		cmd.FinalizePartialTxnCmd,
+		cmd.EncryptWalletCmd,
+		cmd.ChangePassphraseCmd,
+		cmd.UnlockCmd,
+		cmd.LockCmd,
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -1,9 +1,35 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/YouDad/blockchain/core"
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/network"
 	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/wallet"
 )
 
 func TestQueue(t *testing.T) {
@@ -40,4 +66,1130 @@ func TestQueue(t *testing.T) {
 		q.Push(5)
 		t.Log(q.Get())
 	})
+	t.Run("Len", func(t *testing.T) {
+		q := types.NewQueue(5)
+		if q.Len() != 0 {
+			t.Errorf("empty queue's Len is %d", q.Len())
+		}
+		for i := 1; i <= 7; i++ {
+			q.Push(i)
+		}
+		if q.Len() != 5 || q.Get()[0] != 3 {
+			t.Errorf("full queue's Len is %d, front is %v", q.Len(), q.Get()[0])
+		}
+		q.Pop()
+		if q.Len() != 4 {
+			t.Errorf("Len after Pop is %d", q.Len())
+		}
+	})
+}
+
+func TestRelayPolicy(t *testing.T) {
+	pubKeyHash := make(types.HashValue, 20)
+	in := types.TxnInput{VoutHash: pubKeyHash, VoutValue: 10, Signature: []byte{1}, PubKey: []byte{1}}
+	out := types.TxnOutput{Value: 10, PubKeyHash: pubKeyHash}
+	prevOuts := []*types.TxnOutput{&out}
+
+	txn := types.Transaction{Vin: []types.TxnInput{in}, Vout: []types.TxnOutput{out}}
+	if err := core.RelayPolicy.CheckTransaction(txn, prevOuts); err != nil {
+		t.Fatal(err)
+	}
+
+	txn.Vout[0].Value = -1
+	if err := core.RelayPolicy.CheckTransaction(txn, prevOuts); err != core.ErrNegativeValue {
+		t.Fatal("negative value accepted", err)
+	}
+
+	txn.Vout[0].Value = 0
+	if err := core.RelayPolicy.CheckTransaction(txn, prevOuts); !errors.Is(err, core.ErrNotStandard) {
+		t.Fatal("dust output accepted", err)
+	}
+
+	txn.Vout = []types.TxnOutput{out, out}
+	txn.Vout[0].Value = math.MaxInt64
+	if err := core.RelayPolicy.CheckTransaction(txn, prevOuts); err != core.ErrValueOverflow {
+		t.Fatal("overflow accepted", err)
+	}
+
+	txn.Vout = []types.TxnOutput{out}
+	txn.Vin = []types.TxnInput{in, in}
+	err := core.RelayPolicy.CheckTransaction(txn, []*types.TxnOutput{&out, &out})
+	if !errors.Is(err, core.ErrNotStandard) {
+		t.Fatal("duplicate input accepted", err)
+	}
+
+	// 输入金额以引用的输出为准，交易自己多写的金额不算
+	txn.Vin = []types.TxnInput{in}
+	txn.Vin[0].VoutValue = 20
+	txn.Vout[0].Value = 20
+	if err := core.RelayPolicy.CheckTransaction(txn, prevOuts); !errors.Is(err, core.ErrNotStandard) {
+		t.Fatal("inflated input accepted", err)
+	}
+	// 其他组转发来的交易不知道引用的输出，不检查总额
+	if err := core.RelayPolicy.CheckTransaction(txn, make([]*types.TxnOutput, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	var dust types.Amount
+	if err := dust.Set("0.5"); err != nil || dust != types.Coin/2 {
+		t.Fatal("dust limit is parsed as", dust, err)
+	}
+}
+
+// 构造一个有txnNum笔交易、每笔inNum个输入的区块的签名验证任务
+func newInputJobs(b *testing.B, txnNum, inNum int) []types.InputJob {
+	sk, pubKey := newTestKey(b)
+
+	prevTxn := types.Transaction{}
+	for i := 0; i < inNum; i++ {
+		prevTxn.Vout = append(prevTxn.Vout, types.TxnOutput{Value: 1, PubKeyHash: pubKey.Hash()})
+	}
+	hashedTxn := map[string]types.Transaction{prevTxn.Hash().String(): prevTxn}
+
+	var jobs []types.InputJob
+	for t := 0; t < txnNum; t++ {
+		txn := &types.Transaction{}
+		for i := 0; i < inNum; i++ {
+			txn.Vin = append(txn.Vin, types.TxnInput{
+				VoutHash: prevTxn.Hash(), VoutIndex: i, VoutValue: 1, PubKey: pubKey})
+		}
+		txn.Vout = []types.TxnOutput{{Value: types.Amount(inNum - t), PubKeyHash: pubKey.Hash()}}
+		if err := txn.Sign(types.NewKeySigner(*sk), hashedTxn); err != nil {
+			b.Fatal(err)
+		}
+
+		for i := 0; i < inNum; i++ {
+			jobs = append(jobs, types.InputJob{Txn: txn, Index: i, PrevOut: prevTxn.Vout[i]})
+		}
+	}
+	return jobs
+}
+
+func BenchmarkVerify2000InputsSerial(b *testing.B) {
+	jobs := newInputJobs(b, 20, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !types.VerifyInputs(jobs, 1, nil) {
+			b.Fatal("verify failed")
+		}
+	}
+}
+
+func BenchmarkVerify2000InputsParallel(b *testing.B) {
+	jobs := newInputJobs(b, 20, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !types.VerifyInputs(jobs, runtime.NumCPU(), nil) {
+			b.Fatal("verify failed")
+		}
+	}
+}
+
+func BenchmarkVerify2000InputsCached(b *testing.B) {
+	jobs := newInputJobs(b, 20, 100)
+	cache := types.NewSigCache(len(jobs))
+	types.VerifyInputs(jobs, runtime.NumCPU(), cache)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !types.VerifyInputs(jobs, runtime.NumCPU(), cache) {
+			b.Fatal("verify failed")
+		}
+	}
+}
+
+func TestAmount(t *testing.T) {
+	if s := (50 * types.Coin).String(); s != "50.000000" {
+		t.Fatal(s)
+	}
+	if s := types.Amount(-1).String(); s != "-0.000001" {
+		t.Fatal(s)
+	}
+
+	amount, err := types.ParseAmount("1.5")
+	if err != nil || amount != types.Coin+types.Coin/2 {
+		t.Fatal(amount, err)
+	}
+	if _, err := types.ParseAmount("0.0000001"); err != types.ErrAmountFormat {
+		t.Fatal("too many decimals accepted", err)
+	}
+
+	if _, err := types.MaxMoney.Add(1); err != types.ErrAmountRange {
+		t.Fatal("max money exceeded", err)
+	}
+	if _, err := types.Amount(math.MaxInt64).Add(1); err != types.ErrAmountOverflow {
+		t.Fatal("overflow accepted", err)
+	}
+	if _, err := types.Amount(0).Sub(1); err != types.ErrAmountRange {
+		t.Fatal("negative accepted", err)
+	}
+}
+
+func newTestKey(t testing.TB) (*ecdsa.PrivateKey, types.PublicKey) {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := make(types.PublicKey, 64)
+	sk.PublicKey.X.FillBytes(pubKey[:32])
+	sk.PublicKey.Y.FillBytes(pubKey[32:])
+	return sk, pubKey
+}
+
+func TestPartialTxn(t *testing.T) {
+	sk1, pk1 := newTestKey(t)
+	sk2, pk2 := newTestKey(t)
+	prevTxn := types.Transaction{Vout: []types.TxnOutput{
+		{Value: 5, PubKeyHash: pk1.Hash()}, {Value: 7, PubKeyHash: pk2.Hash()}}}
+
+	raw := types.RawTxn{
+		Txn: types.Transaction{
+			Vin: []types.TxnInput{
+				{VoutHash: prevTxn.Hash(), VoutIndex: 0, VoutValue: 5},
+				{VoutHash: prevTxn.Hash(), VoutIndex: 1, VoutValue: 7}},
+			Vout: []types.TxnOutput{{Value: 12, PubKeyHash: pk1.Hash()}},
+		},
+		PrevOuts: prevTxn.Vout,
+	}
+
+	p1 := types.NewPartialTxn(raw)
+	p2 := types.NewPartialTxn(raw)
+	if n, err := p1.Sign(types.NewKeySigner(*sk1)); n != 1 || err != nil {
+		t.Fatal(n, err)
+	}
+	if n, err := p2.Sign(types.NewKeySigner(*sk2)); n != 1 || err != nil {
+		t.Fatal(n, err)
+	}
+	if _, err := p1.Finalize(); err != types.ErrPartialTxnIncomplete {
+		t.Fatal("incomplete transaction finalized", err)
+	}
+
+	if err := p1.Combine(p2); err != nil {
+		t.Fatal(err)
+	}
+	final, err := p1.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !final.Txn.Verify(map[string]types.Transaction{prevTxn.Hash().String(): prevTxn}) {
+		t.Fatal("finalized transaction verify failed")
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	m := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	if err := wallet.ValidateMnemonic(m); err != nil {
+		t.Fatal(err)
+	}
+	seed := hex.EncodeToString(wallet.MnemonicToSeed(m, "TREZOR"))
+	if seed != "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04" {
+		t.Fatal(seed)
+	}
+
+	bad := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"
+	if err := wallet.ValidateMnemonic(bad); err != wallet.ErrMnemonic {
+		t.Fatal("bad checksum accepted", err)
+	}
+
+	m, err := wallet.NewMnemonic()
+	if err != nil || wallet.ValidateMnemonic(m) != nil {
+		t.Fatal(m, err)
+	}
+}
+
+func TestPrivateKeyEncoding(t *testing.T) {
+	w := wallet.NewWallet()
+	s := wallet.EncodePrivateKey(w.PrivateKey)
+	sk, err := wallet.DecodePrivateKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wallet.NewWalletFromPrivateKey(sk).String() != w.String() {
+		t.Fatal("address mismatch")
+	}
+
+	bad := []byte(s)
+	if bad[5] == '2' {
+		bad[5] = '3'
+	} else {
+		bad[5] = '2'
+	}
+	if _, err := wallet.DecodePrivateKey(string(bad)); err != wallet.ErrKeyEncoding {
+		t.Fatal("bad checksum accepted", err)
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	w := wallet.NewWallet()
+	signature, err := wallet.SignMessage(types.NewKeySigner(w.PrivateKey), "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wallet.VerifyMessage(w.String(), signature, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wallet.VerifyMessage(w.String(), signature, "hello!"); err != wallet.ErrMessageSignature {
+		t.Fatal("wrong message accepted", err)
+	}
+	if err := wallet.VerifyMessage(wallet.NewWallet().String(), signature, "hello"); err != wallet.ErrMessageSignature {
+		t.Fatal("wrong address accepted", err)
+	}
+}
+
+func TestShortAddress(t *testing.T) {
+	for _, address := range []string{"", "1", "1111"} {
+		if wallet.ValidateAddress(address) {
+			t.Errorf("%q is valid", address)
+		}
+		if wallet.AddressToPubKeyHash(address) != nil {
+			t.Errorf("%q has a public key hash", address)
+		}
+	}
+}
+
+func TestExternalSigner(t *testing.T) {
+	// 签名进程一端有私钥，另一端只有地址，两端用管道连接
+	w := wallet.NewWallet()
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- wallet.ServeSigner(wallet.Wallets{w.String(): w}, serverIn, serverOut)
+	}()
+	t.Cleanup(func() { wallet.SetExternalSigner("") })
+	wallet.SetExternalSignerConn(struct {
+		io.Reader
+		io.Writer
+	}{clientIn, clientOut})
+
+	watchOnly := wallet.Wallets{w.String(): &wallet.Wallet{WatchOnly: true}}
+	signer, err := watchOnly.GetSigner(w.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := wallet.SignMessage(signer, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wallet.VerifyMessage(w.String(), signature, "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 签名进程不对任意数据签名，交易由它自己计算签名的数据并检查输入金额
+	if _, err := signer.Sign([]byte("anything")); err != wallet.ErrSignerRawData {
+		t.Fatal("signer signed raw data:", err)
+	}
+	prevOuts := []types.TxnOutput{{Value: 10, PubKeyHash: w.PublicKey.Hash()}}
+	txn := types.Transaction{
+		Vin:  []types.TxnInput{{VoutHash: types.HashValue("prev"), VoutValue: 10, PubKey: w.PublicKey}},
+		Vout: []types.TxnOutput{{Value: 9, PubKeyHash: w.PublicKey.Hash()}},
+	}
+	if err := txn.SignInput(0, signer, prevOuts); err != nil || !txn.VerifyInput(0, prevOuts[0], nil) {
+		t.Fatal("transaction isn't signed:", err)
+	}
+	txn.Vin[0].VoutValue = 20
+	if err := txn.SignInput(0, signer, prevOuts); err == nil {
+		t.Fatal("signer signed an inflated input")
+	}
+
+	other := wallet.NewWallet().String()
+	watchOnly[other] = &wallet.Wallet{WatchOnly: true}
+	if _, err := watchOnly.GetSigner(other); err == nil {
+		t.Fatal("signer without the key accepted")
+	}
+
+	clientOut.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wallet.SetExternalSigner(`exec:signer "unterminated`); err == nil {
+		t.Fatal("unterminated quote accepted")
+	}
+	if err := wallet.SetExternalSigner(`exec: `); err == nil {
+		t.Fatal("empty command accepted")
+	}
+}
+
+func TestPeerFrameLimit(t *testing.T) {
+	// 握手前的消息声称有1MiB，不等内容直接断开
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		network.ServePeer(server, bufio.NewReader(server))
+		close(done)
+	}()
+
+	header := make([]byte, 9)
+	binary.BigEndian.PutUint32(header, 1<<20)
+	if _, err := client.Write(header); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("oversized handshake frame accepted")
+	}
+}
+
+// prev之后的区块，目标难度为1，不需要挖矿
+func newTestBlock(prev *types.Block, reward types.Amount) *types.Block {
+	txn := core.NewCoinbaseTxn(wallet.NewWallet().String())
+	txn.Vout[0].Value = reward
+	txns := []*types.Transaction{txn}
+	return &types.Block{
+		BlockHeader: types.BlockHeader{
+			Height:     prev.Height + 1,
+			PrevHash:   prev.Hash(),
+			Timestamp:  time.Now().UnixNano(),
+			MerkleRoot: core.NewTxnMerkleTree(txns).RootNode.Data,
+			Target:     1.0,
+		},
+		ChukonuHeader: types.ChukonuHeader{BatchSize: 1},
+		Txns:          txns,
+	}
+}
+
+// 测试用的端口和临时数据目录，测试结束后恢复
+func useTestDataDir(t *testing.T, port string) string {
+	oldPort, oldDir := global.Port, global.DataPath("")
+	t.Cleanup(func() {
+		global.Port = oldPort
+		global.SetDataDir(oldDir)
+	})
+	dir := t.TempDir()
+	global.Port = port
+	if err := global.SetDataDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// 只有一个组、创世区块奖励给miner的新链，测试结束后清空
+func newTestChain(t *testing.T, miner *wallet.Wallet) (*core.Blockchain, *types.Block) {
+	maxGroupNum := global.MaxGroupNum
+	global.MaxGroupNum = 1
+	useTestDataDir(t, "test")
+	bc := core.GetBlockchain(0)
+	bc.Clear()
+	t.Cleanup(func() {
+		bc.Clear()
+		global.MaxGroupNum = maxGroupNum
+	})
+
+	genesis, err := core.MineBlocksForCreate(core.NewCoinbaseTxn(miner.String()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc.AddBlock(genesis)
+	core.GetUTXOSet(0).Reindex()
+	bc.TxnReindex()
+	return bc, genesis
+}
+
+func TestSwitchBranch(t *testing.T) {
+	bc, genesis := newTestChain(t, wallet.NewWallet())
+
+	branch := func(n int, rewards ...types.Amount) []*types.Block {
+		prev := genesis
+		var blocks []*types.Block
+		for i := 0; i < n; i++ {
+			reward := core.Subsidy
+			if i < len(rewards) {
+				reward = rewards[i]
+			}
+			prev = newTestBlock(prev, reward)
+			blocks = append(blocks, prev)
+		}
+		return blocks
+	}
+	check := func(blocks []*types.Block) {
+		t.Helper()
+		if h := bc.GetHeight(); h != int32(len(blocks)) {
+			t.Fatalf("height is %d, want %d", h, len(blocks))
+		}
+		if h := core.GetBlockhead(0).GetHeight(); h != int32(len(blocks)) {
+			t.Fatalf("header height is %d, want %d", h, len(blocks))
+		}
+		for _, block := range blocks {
+			if got := bc.GetBlockByHeight(block.Height); got == nil || !got.Hash().Equal(block.Hash()) {
+				t.Fatalf("block %d is not on the branch", block.Height)
+			}
+			header := core.GetBlockhead(0).GetBlockheadByHeight(block.Height)
+			if header == nil || !header.Hash().Equal(block.Hash()) {
+				t.Fatalf("header %d is not on the branch", block.Height)
+			}
+		}
+	}
+
+	a := branch(2)
+	if err := bc.SwitchBranch(genesis, a); err != nil {
+		t.Fatal(err)
+	}
+	check(a)
+
+	// 更长的分叉替换原来的链
+	b := branch(3)
+	if err := bc.SwitchBranch(genesis, b); err != nil {
+		t.Fatal(err)
+	}
+	check(b)
+
+	// 分叉的第二个区块奖励超过上限，恢复原来的链
+	c := branch(4, core.Subsidy, core.Subsidy+1)
+	if err := bc.SwitchBranch(genesis, c); err == nil {
+		t.Fatal("invalid branch accepted")
+	}
+	check(b)
+	set := core.GetUTXOSet(0)
+	if len(set.FindUTXOByHash(c[0].Txns[0].Vout[0].PubKeyHash)) != 0 {
+		t.Fatal("output of the invalid branch is left in the UTXO set")
+	}
+	if len(set.FindUTXOByHash(b[0].Txns[0].Vout[0].PubKeyHash)) != 1 {
+		t.Fatal("output of the restored branch is missing")
+	}
+}
+
+func TestInputValues(t *testing.T) {
+	// 交易记录的输入金额必须和引用的输出一致，输出的总额不能超过引用的输出的总额
+	miner := wallet.NewWallet()
+	bc, genesis := newTestChain(t, miner)
+	coinbase := genesis.Txns[0]
+	spend := func(claimed, paid types.Amount) (*types.Transaction, *types.Block) {
+		txn := &types.Transaction{
+			Vin: []types.TxnInput{{VoutHash: coinbase.Hash(), VoutIndex: 0,
+				VoutValue: claimed, PubKey: miner.PublicKey}},
+			Vout: []types.TxnOutput{{Value: paid, PubKeyHash: miner.PublicKey.Hash()}},
+		}
+		err := txn.SignInput(0, types.NewKeySigner(miner.PrivateKey), coinbase.Vout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := newTestBlock(genesis, core.Subsidy)
+		block.Txns = append(block.Txns, txn)
+		block.MerkleRoot = core.NewTxnMerkleTree(block.Txns).RootNode.Data
+		return txn, block
+	}
+
+	txn, block := spend(core.Subsidy, core.Subsidy)
+	if err := bc.VerifyTransaction(*txn); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifyBlockTxns(block); err != nil {
+		t.Fatal(err)
+	}
+
+	txn, block = spend(2*core.Subsidy, 2*core.Subsidy)
+	if err := bc.VerifyTransaction(*txn); err != types.ErrInputValue {
+		t.Fatal("inflated input accepted", err)
+	}
+	if err := bc.VerifyBlockTxns(block); err == nil ||
+		!strings.Contains(err.Error(), types.ErrInputValue.Error()) {
+		t.Fatal("block with inflated input accepted", err)
+	}
+
+	txn, block = spend(core.Subsidy, core.Subsidy+1)
+	if err := bc.VerifyTransaction(*txn); err != types.ErrInsufficientInput {
+		t.Fatal("outputs exceeding inputs accepted", err)
+	}
+	if err := bc.VerifyBlockTxns(block); err == nil {
+		t.Fatal("block with outputs exceeding inputs accepted")
+	}
+}
+
+// 临时数据目录中的空钱包，测试结束后重新读取原来的钱包
+func useTestWallets(t *testing.T) wallet.Wallets {
+	t.Cleanup(func() { wallet.ReloadWallets() })
+	useTestDataDir(t, "test")
+	ws, err := wallet.ReloadWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func TestChangeAddress(t *testing.T) {
+	// 找零地址从找零路径派生，并发发送也不会拿到同一个找零地址
+	ws := useTestWallets(t)
+	seed := make([]byte, 32)
+	rand.Read(seed)
+	if err := ws.SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	miner := wallet.NewWallet()
+	ws.AddWallet(miner)
+	newTestChain(t, miner)
+
+	changes := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			txn, err := core.GetUTXOSet(0).CreateTransaction(miner.String(),
+				wallet.NewWallet().String(), core.Subsidy/4)
+			if err != nil || len(txn.Vout) != 2 {
+				t.Error(err)
+				changes <- ""
+				return
+			}
+			changes <- wallet.PubKeyHashToAddress(txn.Vout[1].PubKeyHash)
+		}()
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		change := <-changes
+		w, ok := ws.GetWallet(change)
+		if !ok || !w.HD || !w.Internal || !strings.Contains(w.HDPath(), "/1'/") {
+			t.Fatal("change is not an internal HD address", change)
+		}
+		if seen[change] {
+			t.Fatal("change address reused", change)
+		}
+		seen[change] = true
+	}
+
+	ws, err := wallet.ReloadWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for change := range seen {
+		if _, ok := ws.GetWallet(change); !ok {
+			t.Fatal("change address not saved", change)
+		}
+	}
+}
+
+func TestWalletHistory(t *testing.T) {
+	// 区块连接时记录收支，断开时删除，重建后和逐块记录的一样
+	ws := useTestWallets(t)
+	miner := wallet.NewWallet()
+	ws.AddWallet(miner)
+	bc, genesis := newTestChain(t, miner)
+	set := core.GetUTXOSet(0)
+	history := core.GetWalletHistory(0)
+
+	to := wallet.NewWallet().String()
+	txn, err := set.CreateTransaction(miner.String(), to, core.Subsidy/4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := wallet.PubKeyHashToAddress(txn.Vout[1].PubKeyHash)
+	block := newTestBlock(genesis, core.Subsidy)
+	block.Txns = append(block.Txns, txn)
+	block.MerkleRoot = core.NewTxnMerkleTree(block.Txns).RootNode.Data
+	bc.AddBlock(block)
+
+	check := func(want map[string]types.Amount) {
+		t.Helper()
+		got := make(map[string]types.Amount)
+		for _, wt := range history.GetTxns("") {
+			got[fmt.Sprint(wt.Height, wt.Address)] = wt.Amount
+			// 自己的找零地址不算作对方
+			if wt.Height == 1 && (len(wt.Counterparty) != 1 ||
+				wt.Address == change && wt.Counterparty[0] != miner.String() ||
+				wt.Address == miner.String() && wt.Counterparty[0] != to) {
+				t.Fatal("counterparty of", wt.Address, "is", wt.Counterparty)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatal("history is", got, "want", want)
+		}
+	}
+	connected := map[string]types.Amount{
+		"0" + miner.String(): core.Subsidy,
+		"1" + miner.String(): -core.Subsidy,
+		"1" + change:         core.Subsidy - core.Subsidy/4,
+	}
+
+	set.Update(block)
+	check(connected)
+	set.Reverse(block)
+	check(map[string]types.Amount{"0" + miner.String(): core.Subsidy})
+	set.Update(block)
+	set.Reindex()
+	check(connected)
+}
+
+func TestWatchOnly(t *testing.T) {
+	// 只读地址的收入记入钱包，但没有私钥不能花费，导入私钥后才能签名
+	ws := useTestWallets(t)
+	owner := wallet.NewWallet()
+	watch, err := wallet.NewWatchOnlyWallet(owner.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.ImportWatchOnly(watch); err != nil {
+		t.Fatal(err)
+	}
+	bc, _ := newTestChain(t, owner)
+	set := core.GetUTXOSet(0)
+
+	var balance types.Amount
+	for _, utxo := range set.FindUTXOByHash(watch.PubKeyHash) {
+		balance += utxo.Value
+	}
+	wts := core.GetWalletHistory(0).GetTxns(owner.String())
+	if balance != core.Subsidy || len(wts) != 1 || wts[0].Amount != core.Subsidy {
+		t.Fatal("watch-only income isn't counted:", balance, wts)
+	}
+
+	if _, err := ws.GetSigner(owner.String()); err != wallet.ErrWatchOnly {
+		t.Fatal("watch-only address has a signer:", err)
+	}
+	txn, err := set.CreateTransaction(owner.String(), wallet.NewWallet().String(), core.Subsidy/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txn.Vin[0].Signature) != 0 || bc.VerifyTransaction(*txn) == nil {
+		t.Fatal("watch-only address spent")
+	}
+
+	if err := ws.ImportKey(owner); err != nil {
+		t.Fatal(err)
+	}
+	txn, err = set.CreateTransaction(owner.String(), wallet.NewWallet().String(), core.Subsidy/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.VerifyTransaction(*txn); err != nil {
+		t.Fatal("imported key can't spend:", err)
+	}
+}
+
+func TestWalletEncryption(t *testing.T) {
+	// 加密保存后重新读取处于锁定状态，只有正确的口令能解锁，到时间后自动锁定
+	ws := useTestWallets(t)
+	w := wallet.NewWallet()
+	ws.AddWallet(w)
+	if err := ws.Encrypt("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(global.DataPath("wallettest.dat"))
+	if err != nil || strings.Contains(string(content), string(w.PrivateKey.D.Bytes())) {
+		t.Fatal("private key is saved in plaintext", err)
+	}
+
+	ws, err = wallet.ReloadWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wallet.IsEncrypted() || !wallet.IsLocked() {
+		t.Fatal("reloaded wallet is not locked")
+	}
+	if _, err := ws.GetSigner(w.String()); err != wallet.ErrWalletLocked {
+		t.Fatal("locked wallet signs:", err)
+	}
+	if err := ws.Unlock("wrong", 0); err != wallet.ErrPassphrase || !wallet.IsLocked() {
+		t.Fatal("wrong passphrase accepted:", err)
+	}
+
+	if err := ws.Unlock("secret", 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	sk, err := ws.GetPrivateKey(w.String())
+	if err != nil || sk.D.Cmp(w.PrivateKey.D) != 0 {
+		t.Fatal("unlocked key differs", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if !wallet.IsLocked() {
+		t.Fatal("wallet isn't locked after the timeout")
+	}
+
+	// 换口令后旧口令失效，私钥不变
+	if err := ws.ChangePassphrase("secret", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	ws, err = wallet.ReloadWallets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.Unlock("secret", 0); err != wallet.ErrPassphrase {
+		t.Fatal("old passphrase accepted:", err)
+	}
+	if err := ws.Unlock("new secret", 0); err != nil {
+		t.Fatal(err)
+	}
+	sk, err = ws.GetPrivateKey(w.String())
+	if err != nil || sk.D.Cmp(w.PrivateKey.D) != 0 {
+		t.Fatal("key changed with the passphrase", err)
+	}
+}
+
+func TestHDGroups(t *testing.T) {
+	// 每个组从自己已用的最大索引往后派生，重新扫描时每个组分别计算gap limit
+	maxGroupNum := global.MaxGroupNum
+	global.MaxGroupNum = 4
+	defer func() { global.MaxGroupNum = maxGroupNum }()
+	seed := []byte(strings.Repeat("hd groups test seed ", 2))
+	ws := useTestWallets(t)
+	if err := ws.SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+
+	used := make(map[string]bool)
+	var last *wallet.Wallet
+	for i := 0; i < 5; i++ {
+		w, err := ws.NewHDWalletInGroup(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !w.HD || w.Group() != 3 || last != nil && w.HDIndex <= last.HDIndex {
+			t.Fatal("wrong HD address for group 3:", w.HDPath(), w.Group())
+		}
+		ws.AddWallet(w)
+		last = w
+	}
+	if last.HDIndex < 11 {
+		t.Fatal("seed doesn't put other groups' addresses before", last.HDPath())
+	}
+	used[last.String()] = true
+	w, err := ws.NewHDWalletInGroup(0)
+	if err != nil || w.Group() != 0 {
+		t.Fatal("wrong HD address for group 0:", err)
+	}
+	ws.AddWallet(w)
+	used[w.String()] = true
+	change, err := ws.ReserveChangeWallet(1)
+	if err != nil || change.Group() != 1 || !change.Internal {
+		t.Fatal("wrong change address for group 1:", err)
+	}
+	used[change.String()] = true
+
+	// 组3的第5个地址前面至少有5个连续的未使用地址，只按组计算gap limit时才找得到
+	ws = useTestWallets(t)
+	if err := ws.SetSeed(seed); err != nil {
+		t.Fatal(err)
+	}
+	found, err := ws.RescanHD(5, func(addresses []string) ([]bool, error) {
+		result := make([]bool, len(addresses))
+		for i, address := range addresses {
+			result[i] = used[address]
+		}
+		return result, nil
+	})
+	if err != nil || found != len(used) {
+		t.Fatal("rescan found", found, err)
+	}
+	for address := range used {
+		if _, ok := ws.GetWallet(address); !ok {
+			t.Fatal("rescan missed", address)
+		}
+	}
+}
+
+func TestLockDataDir(t *testing.T) {
+	// 同一个数据目录和端口只能被一个进程锁定，进程退出后锁自动释放，
+	// 子进程运行这个测试本身，锁定后等到标准输入关闭再退出
+	if dir := os.Getenv("TEST_LOCK_DATADIR"); dir != "" {
+		global.Port = "locktest"
+		global.SetDataDir(dir)
+		if err := global.LockDataDir(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("locked")
+		ioutil.ReadAll(os.Stdin)
+		os.Exit(0)
+	}
+
+	dir := t.TempDir()
+	lock := func() *exec.Cmd {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestLockDataDir$")
+		cmd.Env = append(os.Environ(), "TEST_LOCK_DATADIR="+dir)
+		return cmd
+	}
+
+	holder := lock()
+	stdin, err := holder.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := holder.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := holder.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Wait()
+	defer stdin.Close()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); line != "locked\n" {
+		t.Fatal("first process didn't lock:", line, err)
+	}
+
+	out, err := lock().CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "in use by another process") {
+		t.Fatal("second process locked the data directory:", string(out))
+	}
+
+	stdin.Close()
+	holder.Wait()
+	if out, err := lock().CombinedOutput(); err != nil {
+		t.Fatal("lock isn't released when the process exits:", string(out))
+	}
+}
+
+func TestCallRetry(t *testing.T) {
+	// 网络错误按指数退避重试，对方返回的错误不重试
+	var mutex sync.Mutex
+	var times []time.Time
+	remote := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		times = append(times, time.Now())
+		refuse := remote
+		mutex.Unlock()
+		if refuse {
+			fmt.Fprint(w, `{"Message":"refused"}`)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	useTestDataDir(t, port)
+	attempts := func() []time.Time {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return times
+	}
+
+	// GetGenesis重试两次，等待200ms和400ms
+	if err := network.CallSelf(network.Context(), "db/GetGenesis", nil, nil); err == nil {
+		t.Fatal("broken connection succeeded")
+	}
+	if times := attempts(); len(times) != 3 {
+		t.Fatal("attempts:", len(times))
+	} else if d := times[1].Sub(times[0]); d < 200*time.Millisecond || d > time.Second {
+		t.Fatal("first backoff is", d)
+	} else if d := times[2].Sub(times[1]); d < 400*time.Millisecond || d > 2*time.Second {
+		t.Fatal("second backoff is", d)
+	}
+
+	mutex.Lock()
+	times, remote = nil, true
+	mutex.Unlock()
+	if err := network.CallSelf(network.Context(), "db/GetGenesis", nil, nil); err == nil ||
+		err.Error() != "refused" || len(attempts()) != 1 {
+		t.Fatal("remote error retried:", len(attempts()), err)
+	}
+
+	// 取消后不再等待
+	ctx, cancel := context.WithCancel(network.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	calls := 0
+	err := network.Retry(ctx, 3, time.Hour, func() error {
+		calls++
+		return errors.New("failed")
+	})
+	if err != context.Canceled || calls != 1 || time.Since(start) > time.Second {
+		t.Fatal("retry isn't canceled:", calls, err)
+	}
+}
+
+func TestBan(t *testing.T) {
+	// 保存的封禁列表在第一次使用时读取，过期的不再生效，以前按地址的封禁换成封禁IP
+	dir := useTestDataDir(t, "bantest")
+	saved, expired := "saved", "expired"
+	hour := time.Now().Add(time.Hour).Format(time.RFC3339)
+	content := fmt.Sprintf(`[{"ID":%q,"Until":%q},{"ID":%q,"Until":%q},
+		{"Address":"198.51.100.7:2000","Until":%q},{"Address":"127.0.0.1:2999","Until":%q}]`,
+		saved, hour, expired, time.Now().Add(-time.Hour).Format(time.RFC3339), hour, hour)
+	err := ioutil.WriteFile(filepath.Join(dir, "bannedbantest.json"), []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !network.IsBanned(saved) || network.IsBanned(expired) {
+		t.Fatal("saved bans are not loaded")
+	}
+	if !network.IsHostBanned("198.51.100.7:2001") || network.IsHostBanned("127.0.0.1:2999") {
+		t.Fatal("address bans are not migrated")
+	}
+
+	network.Misbehave("misbehaved", network.ScoreInvalidRelay, "test")
+	if network.IsBanned("misbehaved") {
+		t.Fatal("banned below the threshold")
+	}
+	network.Misbehave("misbehaved", network.ScoreInvalidRelay, "test")
+	if !network.IsBanned("misbehaved") {
+		t.Fatal("not banned at the threshold")
+	}
+
+	network.Ban("short", 50*time.Millisecond)
+	if !network.IsBanned("short") {
+		t.Fatal("not banned")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if network.IsBanned("short") {
+		t.Fatal("ban doesn't expire")
+	}
+
+	// 封禁写进了文件，过期的已经删除
+	ids := make(map[string]bool)
+	for _, peer := range network.ListBanned() {
+		ids[peer.ID+peer.Host] = true
+	}
+	if len(ids) != 3 || !ids[saved] || !ids["misbehaved"] || !ids["198.51.100.7"] {
+		t.Fatal("banned peers are", ids)
+	}
+	content2, err := ioutil.ReadFile(filepath.Join(dir, "bannedbantest.json"))
+	if err != nil || !strings.Contains(string(content2), "misbehaved") ||
+		strings.Contains(string(content2), expired) || strings.Contains(string(content2), "2999") {
+		t.Fatal("ban file is", string(content2), err)
+	}
+
+	if err := network.Unban("198.51.100.7"); err != nil || network.IsHostBanned("198.51.100.7:2000") {
+		t.Fatal("host is still banned", err)
+	}
+}
+
+func TestLearnAddress(t *testing.T) {
+	useTestDataDir(t, "2104")
+	global.ForgetLearnedAddress()
+	t.Cleanup(global.ForgetLearnedAddress)
+	self := global.SelfAddress()
+
+	// 一个节点说了不算，同一个节点说两次也不算
+	global.LearnAddress("198.51.100.1", "203.0.113.5")
+	global.LearnAddress("198.51.100.1", "203.0.113.5")
+	if global.SelfAddress() != self {
+		t.Fatal("learned from one peer:", global.SelfAddress())
+	}
+
+	global.LearnAddress("198.51.100.2", "203.0.113.5")
+	if global.SelfAddress() != "203.0.113.5:2104" {
+		t.Fatal("not learned from two peers:", global.SelfAddress())
+	}
+
+	// 少数节点看到的地址不会替换多数节点看到的
+	global.LearnAddress("198.51.100.3", "203.0.113.6")
+	global.LearnAddress("198.51.100.4", "127.0.0.1")
+	if global.SelfAddress() != "203.0.113.5:2104" {
+		t.Fatal("replaced by a minority:", global.SelfAddress())
+	}
+}
+
+func TestKnownNodesBound(t *testing.T) {
+	knownNodes := global.GetKnownNodes()
+
+	// 将来的时间按现在算，到期后照样删除
+	future := time.Now().Add(24 * time.Hour).UnixNano()
+	knownNodes.AddNode("198.51.100.9:1", future, 0, 1)
+	if knownNodes.Get()["198.51.100.9:1"].Timestamp > time.Now().UnixNano() {
+		knownNodes.Release()
+		t.Fatal("future timestamp is kept")
+	}
+	knownNodes.Release()
+
+	// 心跳成功过的节点不会被大量新节点挤掉
+	knownNodes.UpdateNode("198.51.100.9:1", 1e6)
+	for i := 0; i < 2000; i++ {
+		knownNodes.AddNode(fmt.Sprintf("198.51.100.10:%d", i), time.Now().UnixNano(), 0, 1)
+	}
+	nodes := knownNodes.Get()
+	_, ok := nodes["198.51.100.9:1"]
+	count := len(nodes)
+	for address := range nodes {
+		delete(nodes, address)
+	}
+	knownNodes.Release()
+	if count > 1024 {
+		t.Fatal("known nodes aren't bounded:", count)
+	}
+	if !ok {
+		t.Fatal("good node is evicted")
+	}
+}
+
+func TestPeerHandshake(t *testing.T) {
+	// 握手后加密的请求可以调用，篡改的记录让对方断开连接
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		network.ServePeer(server, bufio.NewReader(server))
+		close(done)
+	}()
+	if err := network.ConnectPeer(client, "198.51.100.30:1111"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := network.Context()
+	if err := network.CallBack(ctx, "198.51.100.30:1111", "net/HeartBeat", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	record := make([]byte, 4+32)
+	binary.BigEndian.PutUint32(record, 32)
+	if _, err := client.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("tampered record accepted")
+	}
+
+	// 绑定了节点ID的地址上是别的节点时握手失败
+	t.Cleanup(func() {
+		nodes := global.GetKnownNodes().Get()
+		delete(nodes, "198.51.100.30:1111")
+		delete(nodes, "198.51.100.31:1111")
+		global.GetKnownNodes().Release()
+	})
+	global.GetKnownNodes().AddSeed("198.51.100.31:1111", 1)
+	global.GetKnownNodes().SetID("198.51.100.31:1111", strings.Repeat("00", 20))
+	client, server = net.Pipe()
+	defer client.Close()
+	go network.ServePeer(server, bufio.NewReader(server))
+	if err := network.ConnectPeer(client, "198.51.100.31:1111"); !errors.Is(err, network.ErrPeerIdentity) {
+		t.Fatal("pinned node ID isn't checked:", err)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	// 请求过的对象在完成前不重复请求，同一个哈希在不同的组分别请求
+	hash := types.HashValue(strings.Repeat("i", 32))
+	items := []network.InvItem{
+		{Type: network.InvTxn, Group: 0, Hash: hash},
+		{Type: network.InvTxn, Group: 1, Hash: hash},
+		{Type: network.InvBlock, Group: 0, Hash: hash},
+	}
+	defer network.DoneInv(items)
+	if got := network.RequestInv(append(items, items[0])); len(got) != 3 {
+		t.Fatal("requested", got)
+	}
+	if got := network.RequestInv(items); len(got) != 0 {
+		t.Fatal("requested again", got)
+	}
+	network.DoneInv(items[:1])
+	if got := network.RequestInv(items); len(got) != 1 || got[0].Key() != items[0].Key() {
+		t.Fatal("done item isn't requested again", got)
+	}
+
+	// 对象太多的广播被拒绝
+	client, server := net.Pipe()
+	defer client.Close()
+	go network.ServePeer(server, bufio.NewReader(server))
+	t.Cleanup(func() {
+		nodes := global.GetKnownNodes().Get()
+		delete(nodes, "198.51.100.32:1111")
+		global.GetKnownNodes().Release()
+	})
+	if err := network.ConnectPeer(client, "198.51.100.32:1111"); err != nil {
+		t.Fatal(err)
+	}
+	inv := make([]network.InvItem, network.MaxInvItems+1)
+	for i := range inv {
+		inv[i] = network.InvItem{Type: network.InvTxn, Hash: hash}
+	}
+	err := network.CallBack(network.Context(), "198.51.100.32:1111", "db/Inv", &inv, nil)
+	if err == nil || !strings.Contains(err.Error(), "Too many items") {
+		t.Fatal("oversized inv accepted:", err)
+	}
 }
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"context"
 	"time"
 
 	"github.com/YouDad/blockchain/global"
@@ -8,8 +9,8 @@ import (
 
 type NET struct{}
 
-func heartBeat(address string) {
-	call(address, "net/HeartBeat", nil, nil)
+func heartBeat(ctx context.Context, address string) error {
+	return call(ctx, address, "net/HeartBeat", nil, nil)
 }
 
 func (net *NET) HeartBeat() error {
@@ -26,19 +27,21 @@ type GetKnownNodesReply = struct {
 	Addresses []GetKnownNodesArgs
 }
 
-func getKnownNodes(myAddress string, knownNodeAddresses *[]GetKnownNodesArgs) error {
+func getKnownNodes(ctx context.Context, myAddress string, knownNodeAddresses *[]GetKnownNodesArgs) error {
 	args := GetKnownNodesArgs{myAddress, time.Now().UnixNano(), global.GetGroup(), global.GroupNum}
 	var reply GetKnownNodesReply
 
-	err, _ := CallInterGroup("net/GetKnownNodes", &args, &reply)
+	err, _ := CallInterGroup(ctx, "net/GetKnownNodes", &args, &reply)
 	*knownNodeAddresses = reply.Addresses
 	return err
 }
 
 func (net *NET) GetKnownNodes(args *GetKnownNodesArgs, reply *GetKnownNodesReply) error {
 	knownNodes := global.GetKnownNodes()
-	if args.Address != "" {
-		knownNodes.AddNode(args.Address, args.Timestamp, args.GroupBase, args.GroupNumber)
+	// 新节点马上参与广播，不等下一次心跳
+	if args.Address != "" &&
+		knownNodes.AddNode(args.Address, args.Timestamp, args.GroupBase, args.GroupNumber) {
+		UpdateSortedNodes()
 	}
 
 	var nodes []GetKnownNodesArgs
@@ -0,0 +1,339 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// 分数同时按节点ID和连接的IP累计，封禁节点ID时也封禁它连接的IP，换ID或者换地址都不能绕过封禁，
+// IP取自连接本身，冒用别人的地址不会连累别人，回环地址不按IP封禁，同一台机器上可以运行多个节点，
+// 分数达到banThreshold的节点被封禁banDuration，分数每过misbehaviorDecay减少banThreshold
+const (
+	banThreshold     = 100
+	banDuration      = 24 * time.Hour
+	misbehaviorDecay = 24 * time.Hour
+	maxMisbehavior   = 4096 // 记录分数的节点ID和IP个数
+	maxPeerHosts     = 4096 // 记录连接IP的节点个数
+)
+
+// 各种不当行为的分数
+const (
+	ScoreMalformed    = 20  // 无法解析的请求
+	ScoreInvalidRelay = 50  // 默克尔路径无效的转发交易
+	ScoreInvalidBlock = 100 // 工作量证明、区块头链或交易无效的区块
+)
+
+var ErrNotBanned = errors.New("Address is not banned")
+
+// 封禁记录，Address是封禁时节点的地址，只用于显示，Host是封禁的IP，
+// 只封禁IP的记录没有ID
+type BannedPeer struct {
+	ID      string
+	Address string
+	Host    string
+	Until   time.Time
+}
+
+// 累计的分数，每过misbehaviorDecay/banThreshold减少一分
+type misbehaviorScore struct {
+	score   int
+	updated time.Time
+}
+
+// now时的分数，updated只前进到最后一次减分的时间
+func (s misbehaviorScore) decay(now time.Time) misbehaviorScore {
+	steps := int(banThreshold * now.Sub(s.updated) / misbehaviorDecay)
+	if steps >= s.score {
+		return misbehaviorScore{0, now}
+	}
+	return misbehaviorScore{s.score - steps,
+		s.updated.Add(time.Duration(steps) * misbehaviorDecay / banThreshold)}
+}
+
+var (
+	mutexBan    sync.Mutex
+	loadedBan   string // 已经读取的封禁列表文件
+	misbehavior = make(map[string]misbehaviorScore)
+	peerHosts   = make(map[string]string)     // 节点ID最近一次连接的IP
+	banned      = make(map[string]BannedPeer) // 按节点ID
+	bannedHosts = make(map[string]BannedPeer) // 按IP
+)
+
+// address中的IP，主机名和回环地址返回空
+func peerIP(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	ip := net.ParseIP(host)
+	if err != nil || ip == nil || ip.IsLoopback() {
+		return ""
+	}
+	return ip.String()
+}
+
+// 记录节点id的连接来自哪个IP
+func rememberPeerHost(id string, remote net.Addr) {
+	host := peerIP(remote.String())
+	if host == "" {
+		return
+	}
+
+	mutexBan.Lock()
+	defer mutexBan.Unlock()
+	loadBanned()
+	if _, ok := peerHosts[id]; !ok && len(peerHosts) >= maxPeerHosts {
+		for other := range peerHosts {
+			delete(peerHosts, other)
+			break
+		}
+	}
+	peerHosts[id] = host
+}
+
+// key的分数加上score，返回现在的分数，调用者持有mutexBan
+func addScore(key string, score int, now time.Time) int {
+	s, ok := misbehavior[key]
+	if !ok && len(misbehavior) >= maxMisbehavior {
+		evictMisbehavior(now)
+	}
+	s = s.decay(now)
+	s.score += score
+	misbehavior[key] = s
+	return s.score
+}
+
+// 删除已经减到0的分数，还是太多时删除分数最低的，调用者持有mutexBan
+func evictMisbehavior(now time.Time) {
+	lowest := ""
+	for key, s := range misbehavior {
+		score := s.decay(now).score
+		if score == 0 {
+			delete(misbehavior, key)
+		} else if lowest == "" || score < misbehavior[lowest].decay(now).score {
+			lowest = key
+		}
+	}
+	if len(misbehavior) >= maxMisbehavior {
+		delete(misbehavior, lowest)
+	}
+}
+
+func banFilename() string {
+	return global.DataPath(fmt.Sprintf("banned%s.json", global.Port))
+}
+
+// 第一次使用或者换了数据目录时读取保存的封禁列表，同时清除累计的分数，调用者持有mutexBan
+func loadBanned() {
+	filename := banFilename()
+	if loadedBan == filename {
+		return
+	}
+	loadedBan = filename
+	misbehavior = make(map[string]misbehaviorScore)
+	peerHosts = make(map[string]string)
+	banned = make(map[string]BannedPeer)
+	bannedHosts = make(map[string]BannedPeer)
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn(err)
+		}
+		return
+	}
+	var peers []BannedPeer
+	log.Warn(jsoniter.Unmarshal(content, &peers))
+	migrated := false
+	for _, peer := range peers {
+		// 以前的封禁只有地址，换成封禁IP，回环地址换成已知的节点ID
+		if peer.ID == "" && peer.Host == "" {
+			peer.Host = peerIP(peer.Address)
+			if peer.Host == "" {
+				peer.ID = global.GetKnownNodes().ID(peer.Address)
+			}
+			if peer.ID == "" && peer.Host == "" {
+				log.Warnf("Ban of %s can't be migrated, it is removed\n", peer.Address)
+			}
+			migrated = true
+		}
+		if peer.ID != "" {
+			banned[peer.ID] = peer
+		}
+		if peer.Host != "" {
+			bannedHosts[peer.Host] = peer
+		}
+	}
+	if migrated {
+		log.Warn(saveBanned())
+	}
+}
+
+// 所有的封禁记录，同时封禁ID和IP的记录只出现一次，调用者持有mutexBan
+func bannedPeers() []BannedPeer {
+	var peers []BannedPeer
+	for _, peer := range banned {
+		peers = append(peers, peer)
+	}
+	for _, peer := range bannedHosts {
+		if peer.ID == "" || banned[peer.ID] != peer {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// 先写临时文件再改名，调用者持有mutexBan
+func saveBanned() error {
+	content, err := jsoniter.MarshalIndent(bannedPeers(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filename := banFilename()
+	err = ioutil.WriteFile(filename+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// 删除到期的封禁，调用者持有mutexBan
+func expireBanned() {
+	now := time.Now()
+	changed := false
+	for id, peer := range banned {
+		if now.After(peer.Until) {
+			delete(banned, id)
+			changed = true
+		}
+	}
+	for host, peer := range bannedHosts {
+		if now.After(peer.Until) {
+			delete(bannedHosts, host)
+			changed = true
+		}
+	}
+	if changed {
+		log.Warn(saveBanned())
+	}
+}
+
+// 记录节点id的不当行为，节点ID或者它连接的IP累计分数达到上限时封禁
+func Misbehave(id string, score int, reason string) {
+	if id == "" || id == SelfID() {
+		return
+	}
+
+	mutexBan.Lock()
+	loadBanned()
+	now := time.Now()
+	total := addScore(id, score, now)
+	host := peerHosts[id]
+	if host != "" {
+		if hostTotal := addScore(host, score, now); hostTotal > total {
+			total = hostTotal
+		}
+	}
+	mutexBan.Unlock()
+
+	log.Warnf("Peer %s (%s) misbehaved (+%d, total %d): %s\n", id, host, score, total, reason)
+	if total >= banThreshold {
+		Ban(id, banDuration)
+	}
+}
+
+// 封禁节点id和它连接的IP一段时间，并断开和它们的连接
+func Ban(id string, d time.Duration) {
+	address := global.GetKnownNodes().AddressOf(id)
+	mutexBan.Lock()
+	loadBanned()
+	host := peerHosts[id]
+	peer := BannedPeer{id, address, host, time.Now().Add(d)}
+	banned[id] = peer
+	delete(misbehavior, id)
+	if host != "" {
+		bannedHosts[host] = peer
+		delete(misbehavior, host)
+	}
+	log.Warn(saveBanned())
+	mutexBan.Unlock()
+	log.Warnf("Banned peer %s (%s %s) until %s\n", id, address, host, peer.Until.Format(time.RFC3339))
+
+	var conns []*peerConn
+	mutexPeerConns.Lock()
+	for _, p := range peerConns {
+		if p.id == id || host != "" && peerIP(p.conn.RemoteAddr().String()) == host {
+			conns = append(conns, p)
+		}
+	}
+	mutexPeerConns.Unlock()
+	for _, p := range conns {
+		p.close()
+	}
+}
+
+func IsBanned(id string) bool {
+	if id == "" {
+		return false
+	}
+	mutexBan.Lock()
+	defer mutexBan.Unlock()
+	loadBanned()
+	peer, ok := banned[id]
+	return ok && time.Now().Before(peer.Until)
+}
+
+// address的IP是否被封禁，主机名不检查，连接后用连接的地址再检查
+func IsHostBanned(address string) bool {
+	host := peerIP(address)
+	if host == "" {
+		return false
+	}
+	mutexBan.Lock()
+	defer mutexBan.Unlock()
+	loadBanned()
+	peer, ok := bannedHosts[host]
+	return ok && time.Now().Before(peer.Until)
+}
+
+// 当前封禁的节点和IP
+func ListBanned() []BannedPeer {
+	mutexBan.Lock()
+	defer mutexBan.Unlock()
+	loadBanned()
+	expireBanned()
+	return bannedPeers()
+}
+
+// 解除封禁并清除累计的分数，peer是节点ID、IP或者封禁时的地址
+func Unban(peer string) error {
+	mutexBan.Lock()
+	defer mutexBan.Unlock()
+	loadBanned()
+	found := false
+	for _, p := range bannedPeers() {
+		if p.ID != peer && p.Host != peer && p.Address != peer {
+			continue
+		}
+		found = true
+		if p.ID != "" {
+			delete(banned, p.ID)
+			delete(misbehavior, p.ID)
+		}
+		if p.Host != "" {
+			delete(bannedHosts, p.Host)
+			delete(misbehavior, p.Host)
+		}
+	}
+	if !found {
+		return ErrNotBanned
+	}
+	return saveBanned()
+}
@@ -2,11 +2,10 @@ package network
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
-	"time"
 
 	"github.com/YouDad/blockchain/global"
 	"github.com/YouDad/blockchain/log"
@@ -14,53 +13,80 @@ import (
 	jsoniter "github.com/json-iterator/go"
 )
 
-func call(node, method string, args interface{}, reply interface{}) error {
-	log.Infof("call %s's %s, args: %+v", node, method, args)
-	b, err := jsoniter.Marshal(args)
-	if err != nil {
-		return err
+var ErrPeerBanned = errors.New("Peer is banned")
+
+// 节点之间通过长连接协议调用
+func call(ctx context.Context, node, method string, args interface{}, reply interface{}) error {
+	if IsBanned(PeerID(node)) || IsHostBanned(node) {
+		return ErrPeerBanned
 	}
-	resp, err := http.Post(fmt.Sprintf("http://%s/v1/%s?address=127.0.0.1:%s", node, method, global.Port),
-		"application/json;charset=UTF-8", bytes.NewBuffer(b))
+	log.Debugf("call %s's %s\n", node, method)
+	return withPolicy(ctx, method, func(ctx context.Context) error {
+		return callPeer(ctx, node, method, args, reply)
+	})
+}
+
+// 客户端通过HTTP接口调用，参数中可能有口令和私钥，不写进日志
+func callHTTP(ctx context.Context, node, method string, args interface{}, reply interface{}) error {
+	log.Debugf("call %s's %s\n", node, method)
+	b, err := jsoniter.Marshal(args)
 	if err != nil {
 		return err
 	}
 
-	type SimpleJSONResult struct {
-		Message string
-		Data    interface{}
-	}
-	var ret SimpleJSONResult
-	ret.Data = reply
+	return withPolicy(ctx, method, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+			fmt.Sprintf("http://%s/v1/%s?address=127.0.0.1:%s", node, method, global.Port),
+			bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
 
-	jsoniter.NewDecoder(resp.Body).Decode(&ret)
-	if ret.Message != "" {
-		log.Debugln("call return error:", ret.Message)
-		return errors.New(ret.Message)
-	}
-	return nil
+		type SimpleJSONResult struct {
+			Message string
+			Data    interface{}
+		}
+		var ret SimpleJSONResult
+		ret.Data = reply
+
+		jsoniter.NewDecoder(resp.Body).Decode(&ret)
+		if ret.Message != "" {
+			log.Debugln("call return error:", ret.Message)
+			return &remoteError{ret.Message}
+		}
+		return nil
+	})
 }
 
-func CallBack(node, method string, args interface{}, reply interface{}) error {
+func CallBack(ctx context.Context, node, method string, args interface{}, reply interface{}) error {
 	log.SetCallerLevel(1)
 	log.Debugln("Callback", method)
 	log.SetCallerLevel(0)
-	return call(node, method, args, reply)
+	return call(ctx, node, method, args, reply)
 }
 
-func CallSelf(method string, args interface{}, reply interface{}) error {
+func CallSelf(ctx context.Context, method string, args interface{}, reply interface{}) error {
 	log.SetCallerLevel(1)
 	log.Debugln("CallMySelf", method)
 	log.SetCallerLevel(0)
-	return call("127.0.0.1:"+global.Port, method, args, reply)
+	return callHTTP(ctx, "127.0.0.1:"+global.Port, method, args, reply)
 }
 
-func CallInterGroup(method string, args interface{}, reply interface{}) (error, string) {
+func CallInterGroup(ctx context.Context, method string, args interface{}, reply interface{}) (error, string) {
 	log.SetCallerLevel(1)
 	log.Debugln("CallInterGroup", method)
 	log.SetCallerLevel(0)
 	for _, node := range GetSortedNodes() {
-		err := call(node.Address, method, args, reply)
+		if ctx.Err() != nil {
+			return ctx.Err(), ""
+		}
+		err := call(ctx, node.Address, method, args, reply)
 		if err != nil {
 			log.Warnln("CallInterGroup", node.Address, err)
 			continue
@@ -70,19 +96,30 @@ func CallInterGroup(method string, args interface{}, reply interface{}) (error,
 	return errors.New("None of the nodes responded!"), ""
 }
 
-func CallInnerGroup(method string, args interface{}, reply interface{}) (error, string) {
+func CallInnerGroup(ctx context.Context, method string, args interface{}, reply interface{}) (error, string) {
 	log.SetCallerLevel(1)
 	log.Debugln("CallInnerGroup", method)
 	log.SetCallerLevel(0)
-	for _, node := range GetSortedNodes() {
-		// 分组检查
-		if !utils.InGroup(global.GetGroup(), node.GroupBase, node.GroupNumber, global.MaxGroupNum) {
-			continue
+	return callGroup(ctx, global.GetGroup(), method, args, reply)
+}
+
+// 调用负责group组的节点
+func CallGroup(ctx context.Context, group int, method string, args interface{}, reply interface{}) (error, string) {
+	log.SetCallerLevel(1)
+	log.Debugln("CallGroup", group, method)
+	log.SetCallerLevel(0)
+	return callGroup(ctx, group, method, args, reply)
+}
+
+func callGroup(ctx context.Context, group int, method string, args interface{}, reply interface{}) (error, string) {
+	for _, node := range GetGroupNodes(group) {
+		if ctx.Err() != nil {
+			return ctx.Err(), ""
 		}
 
-		err := call(node.Address, method, args, reply)
+		err := call(ctx, node.Address, method, args, reply)
 		if err != nil {
-			log.Warnln("CallInnerGroup", node.Address, err)
+			log.Warnln("CallGroup", node.Address, err)
 			continue
 		}
 		return nil, node.Address
@@ -90,13 +127,13 @@ func CallInnerGroup(method string, args interface{}, reply interface{}) (error,
 	return errors.New("None of the nodes responded!"), ""
 }
 
-func send(node Position, method string, args interface{}, reply interface{}) bool {
+func send(ctx context.Context, node Position, method string, args interface{}, reply interface{}) bool {
 	// 分组检查
 	if !utils.InGroup(global.GetGroup(), node.GroupBase, node.GroupNumber, global.MaxGroupNum) {
 		return false
 	}
 
-	err := call(node.Address, method, args, reply)
+	err := call(ctx, node.Address, method, args, reply)
 	if err != nil {
 		log.Debugln("GossipCall", node.Address, method, err)
 	} else {
@@ -105,26 +142,27 @@ func send(node Position, method string, args interface{}, reply interface{}) boo
 	return err == nil
 }
 
-func GossipCallSpecialGroup(method string, args interface{},
+// 广播时发给代价最小的这么多个节点，由它们继续转发
+const gossipFanout = 5
+
+func GossipCallSpecialGroup(ctx context.Context, method string, args interface{},
 	reply interface{}, targetGroup int, exceptedAddress string) error {
 	log.SetCallerLevel(2)
 	log.Debugln("GossipCall", "start", method, targetGroup)
 	log.SetCallerLevel(0)
 
-	// 打乱数组
-	nodes := sortedNodes
-	for i := range nodes {
-		rand.Seed(time.Now().UnixNano())
-		j := rand.Int()%(len(nodes)-i) + i
-		nodes[i], nodes[j] = nodes[j], nodes[i]
-	}
+	// 优先发给代价小的节点，排序中已经带有随机性
+	nodes := GetSortedNodes()
 
 	success := 0
 	for _, node := range nodes {
-		if node.Address != exceptedAddress && send(node, method, args, reply) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if node.Address != exceptedAddress && send(ctx, node, method, args, reply) {
 			success++
 		}
-		if success >= 5 {
+		if success >= gossipFanout {
 			break
 		}
 	}
@@ -136,10 +174,10 @@ func GossipCallSpecialGroup(method string, args interface{},
 	return nil
 }
 
-func GossipCallInnerGroup(method string, args interface{}, reply interface{}, exceptedAddress string) {
-	log.Warn(GossipCallSpecialGroup(method, args, reply, global.GetGroup(), exceptedAddress))
+func GossipCallInnerGroup(ctx context.Context, method string, args interface{}, reply interface{}, exceptedAddress string) {
+	log.Warn(GossipCallSpecialGroup(ctx, method, args, reply, global.GetGroup(), exceptedAddress))
 }
 
-func GossipCallInterGroup(method string, args interface{}, reply interface{}, exceptedAddress string) {
-	log.Warn(GossipCallSpecialGroup(method, args, reply, -1, exceptedAddress))
+func GossipCallInterGroup(ctx context.Context, method string, args interface{}, reply interface{}, exceptedAddress string) {
+	log.Warn(GossipCallSpecialGroup(ctx, method, args, reply, -1, exceptedAddress))
 }
@@ -0,0 +1,136 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ctxRoot, cancelRoot = context.WithCancel(context.Background())
+
+// 节点的根context，Shutdown时取消，不属于某个请求的调用都从它派生
+func Context() context.Context {
+	return ctxRoot
+}
+
+// 取消所有进行中的调用并关闭节点间的连接
+func Shutdown() {
+	cancelRoot()
+	saveKnownNodes()
+
+	mutexPeerConns.Lock()
+	conns := make([]*peerConn, 0, len(peerConns))
+	for _, p := range peerConns {
+		conns = append(conns, p)
+	}
+	mutexPeerConns.Unlock()
+	for _, p := range conns {
+		p.close()
+	}
+}
+
+// 对方处理后返回的错误，重试也不会成功
+type remoteError struct {
+	message string
+}
+
+func (e *remoteError) Error() string {
+	return e.message
+}
+
+// 方法的超时和失败后的重试次数，有副作用的方法不重试
+type callPolicy struct {
+	Timeout time.Duration
+	Retries int
+}
+
+var defaultCallPolicy = callPolicy{10 * time.Second, 0}
+
+var callPolicies = map[string]callPolicy{
+	"net/HeartBeat":          {3 * time.Second, 0},
+	"net/GetKnownNodes":      {5 * time.Second, 2},
+	"version/SendVersion":    {5 * time.Second, 2},
+	"db/GetGenesis":          {10 * time.Second, 2},
+	"db/GetBalance":          {10 * time.Second, 2},
+	"db/GetBalances":         {10 * time.Second, 2},
+	"db/FindUsedAddresses":   {30 * time.Second, 2},
+	"db/GetBlocks":           {60 * time.Second, 2},
+	"db/GetHash":             {5 * time.Second, 2},
+	"db/Inv":                 {3 * time.Second, 0},
+	"db/GetData":             {30 * time.Second, 2},
+	"db/GetHeaders":          {30 * time.Second, 2},
+	"server/SendCMD":         {30 * time.Second, 0},
+	"wallet/Unlock":          {30 * time.Second, 0},
+	"wallet/Rescan":          {10 * time.Minute, 0},
+	"wallet/ImportKey":       {10 * time.Minute, 0},
+	"wallet/ImportWatchOnly": {10 * time.Minute, 0},
+}
+
+const (
+	retryBackoff    = 200 * time.Millisecond
+	maxRetryBackoff = 5 * time.Second
+)
+
+func getCallPolicy(method string) callPolicy {
+	if policy, ok := callPolicies[method]; ok {
+		return policy
+	}
+	return defaultCallPolicy
+}
+
+// 按方法的超时调用fn，网络错误时按指数退避重试
+func withPolicy(ctx context.Context, method string, fn func(ctx context.Context) error) error {
+	policy := getCallPolicy(method)
+	backoff := retryBackoff
+	for i := 0; ; i++ {
+		callCtx, cancel := context.WithTimeout(ctx, policy.Timeout)
+		err := fn(callCtx)
+		cancel()
+
+		var remote *remoteError
+		if err == nil || errors.As(err, &remote) || i >= policy.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		if err := Sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// 等待d，ctx取消时提前返回
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 重复调用fn直到成功，最多attempts次，每次失败后等待的时间加倍
+func Retry(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i == attempts-1 {
+			break
+		}
+		if err := Sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+)
+
+// 每个节点有一个长期的身份密钥，节点ID是公钥的哈希，
+// 握手时双方签名对方的随机数，证明自己持有ID对应的私钥
+var ErrPeerIdentity = errors.New("peer identity doesn't match")
+
+var (
+	onceIdentity sync.Once
+	identity     *types.KeySigner
+)
+
+func identityFilename() string {
+	return global.DataPath(fmt.Sprintf("node%s.key", global.Port))
+}
+
+// 本节点的身份密钥，第一次使用时读取，没有时生成并保存，没有端口的客户端用临时密钥
+func getIdentity() *types.KeySigner {
+	onceIdentity.Do(func() {
+		curve := elliptic.P256()
+		if global.Port != "" {
+			content, err := ioutil.ReadFile(identityFilename())
+			if err == nil {
+				d, err := hex.DecodeString(string(bytes.TrimSpace(content)))
+				if err != nil || len(d) != 32 {
+					log.Errln("Identity key file", identityFilename(), "is broken")
+				}
+				sk := types.PrivateKey{D: new(big.Int).SetBytes(d)}
+				sk.PublicKey.Curve = curve
+				sk.PublicKey.X, sk.PublicKey.Y = curve.ScalarBaseMult(d)
+				identity = types.NewKeySigner(sk)
+				return
+			}
+			if !os.IsNotExist(err) {
+				log.Err(err)
+			}
+		}
+
+		sk, err := ecdsa.GenerateKey(curve, rand.Reader)
+		log.Err(err)
+		identity = types.NewKeySigner(*sk)
+		if global.Port != "" {
+			d := make([]byte, 32)
+			sk.D.FillBytes(d)
+			log.Err(ioutil.WriteFile(identityFilename(), []byte(hex.EncodeToString(d)+"\n"), 0600))
+			log.Infof("Generated node identity %s\n", NodeID(identity.PublicKey()))
+		}
+	})
+	return identity
+}
+
+// 公钥对应的节点ID
+func NodeID(pubKey types.PublicKey) string {
+	return pubKey.Hash().String()
+}
+
+func SelfID() string {
+	return NodeID(getIdentity().PublicKey())
+}
+
+// 握手中签名的内容，role区分双方，防止把对方的签名原样发回来，
+// 签名中包括本方的加密设置和临时公钥，中间人不能去掉加密
+func handshakeDigest(role string, nonce []byte, hs *peerHandshake) []byte {
+	digest := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%x/%s/%d/%x",
+		PeerUpgrade, role, nonce, hs.Address, hs.Encryption, hs.Ephemeral)))
+	return digest[:]
+}
+
+// 用身份密钥签名对方的随机数和hs中本方的信息
+func signHandshake(role string, nonce []byte, hs *peerHandshake) (types.Signature, error) {
+	return getIdentity().Sign(handshakeDigest(role, nonce, hs))
+}
+
+func verifyHandshake(pubKey types.PublicKey, signature types.Signature, role string,
+	nonce []byte, hs *peerHandshake) bool {
+	if len(pubKey) != 64 || len(signature) != 64 {
+		return false
+	}
+	pk := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pubKey[:32]),
+		Y:     new(big.Int).SetBytes(pubKey[32:]),
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(&pk, handshakeDigest(role, nonce, hs), r, s)
+}
+
+func newNonce() []byte {
+	nonce := make([]byte, 32)
+	_, err := rand.Read(nonce)
+	log.Err(err)
+	return nonce
+}
+
+// 记录address是节点id，已知节点表中同一个ID只保留这个地址
+func bindPeer(address, id string) {
+	if global.GetKnownNodes().SetID(address, id) {
+		UpdateSortedNodes()
+	}
+}
+
+// address对应的节点ID，先看当前的连接，再看已知节点表，没有验证过时为空
+func PeerID(address string) string {
+	mutexPeerConns.Lock()
+	p, ok := peerConns[address]
+	mutexPeerConns.Unlock()
+	if ok {
+		return p.id
+	}
+	return global.GetKnownNodes().ID(address)
+}
+
+// 确认address确实是节点id：地址已经绑定了ID时必须一致，否则地址的IP要和连接的IP一致，
+// 都不满足时连接过去，看对方握手时证明的ID
+func verifyPeerAddress(ctx context.Context, address, id string, remote net.Addr) bool {
+	if address == "" {
+		return false
+	}
+	if global.IsSelf(address) {
+		return id == SelfID()
+	}
+	if bound := PeerID(address); bound != "" {
+		return bound == id
+	}
+	if sameHost(address, remote) {
+		return true
+	}
+
+	p, err := getPeerConn(ctx, address)
+	if err != nil {
+		log.Debugln("verifyPeerAddress", address, err)
+		return false
+	}
+	return p.id == id
+}
+
+// address的主机是否就是连接的另一端
+func sameHost(address string, remote net.Addr) bool {
+	host, _, err := net.SplitHostPort(address)
+	remoteHost, _, err2 := net.SplitHostPort(remote.String())
+	if err != nil || err2 != nil {
+		return false
+	}
+	remoteIP := net.ParseIP(remoteHost)
+	if remoteIP == nil {
+		return false
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, err = net.LookupIP(host)
+		if err != nil {
+			return false
+		}
+	}
+	for _, ip := range ips {
+		if ip.Equal(remoteIP) || ip.IsLoopback() && remoteIP.IsLoopback() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+	"github.com/YouDad/blockchain/utils"
+)
+
+// 广播的对象类型
+const (
+	InvTxn byte = iota + 1
+	InvBlock
+)
+
+// 广播时只发送对象的哈希，对方没有时再来取
+type InvItem struct {
+	Type  byte
+	Group int
+	Hash  types.HashValue
+}
+
+// 同一个哈希在不同的组是不同的对象
+func (item InvItem) Key() string {
+	return fmt.Sprintf("%d/%d/%s", item.Type, item.Group, item.Hash)
+}
+
+const (
+	MaxInvItems       = 50000            // 一次广播或请求的对象个数上限
+	maxKnownInv       = 4096             // 每个节点记住的哈希个数
+	maxInvPeers       = 256              // 记住已知哈希的节点个数
+	invRequestTimeout = 10 * time.Second // 请求过的对象在这段时间内不重复请求
+)
+
+// 一个节点已知的哈希，超过上限时丢弃最早的
+type knownInv struct {
+	keys  map[string]bool
+	order []string
+	used  time.Time
+}
+
+func (k *knownInv) add(key string) {
+	if k.keys[key] {
+		return
+	}
+	if len(k.order) >= maxKnownInv {
+		delete(k.keys, k.order[0])
+		k.order = k.order[1:]
+	}
+	k.keys[key] = true
+	k.order = append(k.order, key)
+}
+
+var (
+	mutexInv     sync.Mutex
+	peerInv      = make(map[string]*knownInv)
+	requestedInv = make(map[string]time.Time)
+)
+
+// 记录address已经有这些对象，之后不再向它广播
+// 节点数超过上限时丢弃最久没有用到的节点
+func MarkKnown(address string, items []InvItem) {
+	if address == "" {
+		return
+	}
+	mutexInv.Lock()
+	defer mutexInv.Unlock()
+	k, ok := peerInv[address]
+	if !ok {
+		if len(peerInv) >= maxInvPeers {
+			oldest := ""
+			for a, inv := range peerInv {
+				if oldest == "" || inv.used.Before(peerInv[oldest].used) {
+					oldest = a
+				}
+			}
+			delete(peerInv, oldest)
+		}
+		k = &knownInv{keys: make(map[string]bool)}
+		peerInv[address] = k
+	}
+	k.used = time.Now()
+	for _, item := range items {
+		k.add(item.Key())
+	}
+}
+
+// 过滤掉最近已经请求过的对象，剩下的记为已请求
+func RequestInv(items []InvItem) []InvItem {
+	mutexInv.Lock()
+	defer mutexInv.Unlock()
+	now := time.Now()
+	for key, t := range requestedInv {
+		if now.Sub(t) > invRequestTimeout {
+			delete(requestedInv, key)
+		}
+	}
+
+	var ret []InvItem
+	for _, item := range items {
+		if _, ok := requestedInv[item.Key()]; ok {
+			continue
+		}
+		requestedInv[item.Key()] = now
+		ret = append(ret, item)
+	}
+	return ret
+}
+
+// 取得对象后清除请求记录，失败时也清除以便向其他节点请求
+func DoneInv(items []InvItem) {
+	mutexInv.Lock()
+	defer mutexInv.Unlock()
+	for _, item := range items {
+		delete(requestedInv, item.Key())
+	}
+}
+
+// address还不知道的对象
+func unknownInv(address string, items []InvItem) []InvItem {
+	mutexInv.Lock()
+	defer mutexInv.Unlock()
+	k := peerInv[address]
+	var ret []InvItem
+	for _, item := range items {
+		if k == nil || !k.keys[item.Key()] {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// 向负责对应分组、还不知道这些对象的节点中代价最小的gossipFanout个同时发送哈希，
+// 等待它们回复，其他节点由它们继续转发
+func GossipInv(ctx context.Context, method string, items []InvItem, exceptedAddress string) {
+	MarkKnown(exceptedAddress, items)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sent := 0
+	for _, node := range GetSortedNodes() {
+		if ctx.Err() != nil || sent >= gossipFanout {
+			return
+		}
+		if global.IsSelf(node.Address) {
+			continue
+		}
+
+		var inv []InvItem
+		for _, item := range unknownInv(node.Address, items) {
+			if utils.InGroup(item.Group, node.GroupBase, node.GroupNumber, global.MaxGroupNum) {
+				inv = append(inv, item)
+			}
+		}
+		if len(inv) == 0 {
+			continue
+		}
+
+		sent++
+		wg.Add(1)
+		go func(address string, inv []InvItem) {
+			defer wg.Done()
+			err := call(ctx, address, method, &inv, nil)
+			if err != nil {
+				log.Debugln("GossipInv", address, err)
+				return
+			}
+			MarkKnown(address, inv)
+		}(node.Address, inv)
+	}
+}
@@ -1,13 +1,22 @@
 package network
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/utils"
 )
 
-var sortedNodes []Position
+var (
+	sortedNodes      []Position
+	mutexSortedNodes sync.RWMutex
+)
 
 type Position struct {
 	Address     string
@@ -16,39 +25,75 @@ type Position struct {
 	GroupNumber int
 }
 
+// 节点排序的参数
+const (
+	unknownReactTime = 500 // 没有测量过响应时间的节点按500毫秒算
+	exploreJitter    = 0.5 // 代价乘以[1, 1+exploreJitter)之间的随机数，让较慢的节点也有机会被选中
+)
+
+// 按响应时间和心跳成功率排序，代价小的在前面，加入一些随机性
 func UpdateSortedNodes() {
-	sortedNodes = nil
+	type rankedNode struct {
+		Position
+		cost float64
+	}
+
+	var nodes []rankedNode
 	knownNodes := global.GetKnownNodes()
-	defer knownNodes.Release()
+	rand.Seed(time.Now().UnixNano())
 	for address, node := range knownNodes.Get() {
-		time := 0
-
-		for _, rt := range node.ReactTime.Get() {
-			reactTime, ok := rt.(int)
-			if ok {
-				time += reactTime
-			}
+		reactTime, ok := node.AverageReactTime()
+		if !ok {
+			reactTime = unknownReactTime
 		}
 
-		sortedNodes = append(sortedNodes, Position{
+		// 响应时间至少按1毫秒算，否则成功率不起作用
+		cost := float64(reactTime+1) / node.SuccessRate()
+		cost *= 1 + rand.Float64()*exploreJitter
+
+		nodes = append(nodes, rankedNode{Position{
 			Address:     address,
-			Distance:    time / 5,
+			Distance:    reactTime,
 			GroupBase:   node.GroupBase,
 			GroupNumber: node.GroupNumber,
-		})
+		}, cost})
 	}
+	knownNodes.Release()
 
-	rand.Seed(time.Now().UnixNano())
-	for i := len(sortedNodes) - 1; i > 0; i-- {
-		j := rand.Int() % i
-		sortedNodes[i], sortedNodes[j] = sortedNodes[j], sortedNodes[i]
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].cost < nodes[j].cost
+	})
+
+	sorted := make([]Position, len(nodes))
+	for i, node := range nodes {
+		sorted[i] = node.Position
+	}
+	mutexSortedNodes.Lock()
+	sortedNodes = sorted
+	mutexSortedNodes.Unlock()
+}
+
+func knownNodesFilename() string {
+	return global.DataPath(fmt.Sprintf("nodes%s.json", global.Port))
+}
+
+// 读取上次保存的节点
+func loadKnownNodes() {
+	log.Warn(global.GetKnownNodes().Load(knownNodesFilename()))
+}
+
+// 删除过期的节点并保存
+func saveKnownNodes() {
+	if n := global.GetKnownNodes().Expire(); n != 0 {
+		log.Infof("Expired %d known nodes\n", n)
 	}
+	log.Warn(global.GetKnownNodes().Save(knownNodesFilename()))
 }
 
-func GetKnownNodes() error {
+func GetKnownNodes(ctx context.Context) error {
 	knownNodes := []GetKnownNodesArgs{}
-	myAddress := "127.0.0.1:" + global.Port
-	err := getKnownNodes(myAddress, &knownNodes)
+	myAddress := global.SelfAddress()
+	err := getKnownNodes(ctx, myAddress, &knownNodes)
 	if err == nil {
 		for _, node := range knownNodes {
 			global.GetKnownNodes().AddNode(node.Address, node.Timestamp, node.GroupBase, node.GroupNumber)
@@ -57,6 +102,42 @@ func GetKnownNodes() error {
 	return err
 }
 
+// 向本节点和已知节点获取节点列表，不把自己登记到对方的列表中
+func GetKnownNodesAnonymously(ctx context.Context) error {
+	var self, others GetKnownNodesReply
+	errSelf := CallSelf(ctx, "net/GetKnownNodes", &GetKnownNodesArgs{}, &self)
+	errOthers, _ := CallInterGroup(ctx, "net/GetKnownNodes", &GetKnownNodesArgs{}, &others)
+	if errSelf != nil && errOthers != nil {
+		return errOthers
+	}
+
+	for _, node := range append(self.Addresses, others.Addresses...) {
+		global.GetKnownNodes().AddNode(node.Address, node.Timestamp, node.GroupBase, node.GroupNumber)
+	}
+	UpdateSortedNodes()
+	return nil
+}
+
+// 按代价从小到大，不包括被封禁的节点
 func GetSortedNodes() []Position {
-	return sortedNodes
+	mutexSortedNodes.RLock()
+	defer mutexSortedNodes.RUnlock()
+	var nodes []Position
+	for _, node := range sortedNodes {
+		if !IsBanned(PeerID(node.Address)) && !IsHostBanned(node.Address) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// 负责group组的节点，按代价从小到大
+func GetGroupNodes(group int) []Position {
+	var nodes []Position
+	for _, node := range GetSortedNodes() {
+		if utils.InGroup(group, node.GroupBase, node.GroupNumber, global.MaxGroupNum) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
 }
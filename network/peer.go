@@ -0,0 +1,700 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+)
+
+// 节点间的长连接协议，先用HTTP Upgrade在HTTP端口上建立TCP连接，之后每条消息是
+//
+//	长度(4字节) 类型(1字节) 请求号(4字节) 内容
+//
+// 请求的内容是方法号(1字节)和gob编码的参数，回复的内容是错误信息的长度(2字节)、
+// 错误信息和gob编码的返回值，同一个连接上可以同时有多个请求
+//
+// 握手时发起方先发送公钥和随机数，对方回复公钥、随机数和对发起方随机数的签名，
+// 发起方验证后再发送对对方随机数的签名，双方都确认了对方的节点ID，
+// 之后双方都要加密时，连接上的内容换成加密记录，见secure.go
+const (
+	PeerUpgrade     = "blockchain-peer"
+	peerVersion     = 3
+	peerMagic       = 0xb10c
+	maxPeerFrame    = 8 << 20  // 最大的正常消息是一个同步窗口的区块或者一次GetData的回复
+	maxPeerBuffered = 16 << 20 // 一个连接上正在处理的请求的总字节数
+	maxHandshake    = 4 << 10  // 握手结束前对方还没有证明身份，消息不能太大
+	maxPeerInflight = 16       // 一个连接上同时处理的请求数
+	peerDialTimeout = 5 * time.Second
+)
+
+// 消息类型
+const (
+	msgHandshake byte = iota
+	msgRequest
+	msgResponse
+)
+
+// 方法号是数组下标，所有节点必须一致，新方法只能加在最后
+var peerMethods = []string{
+	"net/HeartBeat",
+	"net/GetKnownNodes",
+	"version/SendVersion",
+	"db/GetGenesis",
+	"db/GetBalance",
+	"db/GetBalances",
+	"db/FindUsedAddresses",
+	"db/GetBlocks",
+	"db/GossipTxn",
+	"db/SendRawTxn",
+	"db/GossipRelayTxn",
+	"db/GossipBlock",
+	"db/GossipBlockHead",
+	"db/GetHash",
+	"db/Inv",
+	"db/GetData",
+	"db/GetHeaders",
+}
+
+var (
+	ErrPeerClosed   = errors.New("peer connection is closed")
+	ErrPeerProtocol = errors.New("peer protocol error")
+	errPeerReturn   = errors.New("peer request returned")
+)
+
+func peerMethodCode(method string) (byte, error) {
+	for i, m := range peerMethods {
+		if m == method {
+			return byte(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Method %s is not in peer protocol", method)
+}
+
+type peerHandshake struct {
+	Magic    uint16
+	Version  uint16
+	Address  string // 本方可以被连接的地址，匿名调用时为空
+	Observed string // 回复时告诉发起方，它的连接来自哪个IP
+
+	PubKey    types.PublicKey // 身份公钥
+	Nonce     []byte          // 要对方签名的随机数
+	Signature types.Signature // 对对方随机数的签名
+
+	Encryption byte   // 本方的加密设置
+	Ephemeral  []byte // 临时的ECDH公钥，不加密时为空
+}
+
+// 对方声称的地址是回环地址但连接来自其他机器时，用连接的IP代替
+func peerAddress(claimed string, remote net.Addr) string {
+	host, port, err := net.SplitHostPort(claimed)
+	remoteHost, _, err2 := net.SplitHostPort(remote.String())
+	if err != nil || err2 != nil {
+		return claimed
+	}
+
+	ip, remoteIP := net.ParseIP(host), net.ParseIP(remoteHost)
+	if ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) &&
+		remoteIP != nil && !remoteIP.IsLoopback() {
+		return net.JoinHostPort(remoteHost, port)
+	}
+	return claimed
+}
+
+type peerFrame struct {
+	kind byte
+	id   uint32
+	body []byte
+}
+
+func writeFrame(w io.Writer, f peerFrame) error {
+	header := make([]byte, 9)
+	binary.BigEndian.PutUint32(header[:4], uint32(5+len(f.body)))
+	header[4] = f.kind
+	binary.BigEndian.PutUint32(header[5:], f.id)
+	_, err := w.Write(append(header, f.body...))
+	return err
+}
+
+// 读一条消息，长度超过limit时不读内容，直接返回错误
+func readFrame(r io.Reader, limit uint32) (peerFrame, error) {
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return peerFrame{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 5 || length > limit {
+		return peerFrame{}, ErrPeerProtocol
+	}
+
+	f := peerFrame{kind: header[4], id: binary.BigEndian.Uint32(header[5:])}
+	f.body = make([]byte, length-5)
+	_, err := io.ReadFull(r, f.body)
+	return f, err
+}
+
+func gobEncode(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func gobDecode(b []byte, v interface{}) error {
+	if len(b) == 0 || v == nil {
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+// 到一个节点的连接
+type peerConn struct {
+	address    string
+	id         string // 对方握手时证明的节点ID
+	conn       net.Conn
+	reader     *bufio.Reader
+	in         io.Reader // 握手之后读写消息，加密时是加密的连接
+	out        io.Writer
+	writeMutex sync.Mutex
+	mutex      sync.Mutex
+	nextID     uint32
+	pending    map[uint32]chan peerFrame
+	closed     bool
+}
+
+var (
+	peerConns      = make(map[string]*peerConn)
+	mutexPeerConns sync.Mutex
+)
+
+// 取出到address的连接，没有时建立连接
+func getPeerConn(ctx context.Context, address string) (*peerConn, error) {
+	mutexPeerConns.Lock()
+	p, ok := peerConns[address]
+	mutexPeerConns.Unlock()
+	if ok {
+		return p, nil
+	}
+
+	// 建立连接时不持有锁，同时建立了两个连接时用先放进去的
+	p, err := dialPeer(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return addPeerConn(p), nil
+}
+
+// 记录握手完成的连接，已经有到同一个地址的连接时关闭p，返回原来的连接
+func addPeerConn(p *peerConn) *peerConn {
+	bindPeer(p.address, p.id)
+	mutexPeerConns.Lock()
+	defer mutexPeerConns.Unlock()
+	if old, ok := peerConns[p.address]; ok {
+		p.conn.Close()
+		return old
+	}
+	peerConns[p.address] = p
+	go p.readLoop()
+	return p
+}
+
+func newPeerConn(address string, conn net.Conn) *peerConn {
+	p := &peerConn{address: address, conn: conn, reader: bufio.NewReader(conn),
+		pending: make(map[uint32]chan peerFrame)}
+	p.in, p.out = p.reader, p.conn
+	return p
+}
+
+// 在已经建立的连接上作为发起方握手，之后到address的调用都用这个连接，
+// 用于HTTP Upgrade之外的传输
+func ConnectPeer(conn net.Conn, address string) error {
+	conn.SetDeadline(time.Now().Add(peerDialTimeout))
+	p := newPeerConn(address, conn)
+	if err := p.handshake(); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	// 替换到同一个地址的旧连接
+	mutexPeerConns.Lock()
+	old := peerConns[address]
+	delete(peerConns, address)
+	mutexPeerConns.Unlock()
+	if old != nil {
+		old.close()
+	}
+	addPeerConn(p)
+	return nil
+}
+
+func dialPeer(ctx context.Context, address string) (*peerConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, peerDialTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, protocol, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// 握手结束前设置超时，避免对方不回复时一直阻塞
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	p := newPeerConn(address, conn)
+	if err := p.upgrade(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return p, nil
+}
+
+// 发送HTTP Upgrade请求和握手
+func (p *peerConn) upgrade() error {
+	_, err := fmt.Fprintf(p.conn, "POST /v1/net/Peer HTTP/1.1\r\nHost: %s\r\n"+
+		"Connection: Upgrade\r\nUpgrade: %s\r\nContent-Length: 0\r\n\r\n", p.address, PeerUpgrade)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(p.reader, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("%s doesn't support peer protocol: %s", p.address, resp.Status)
+	}
+	return p.handshake()
+}
+
+// 发起方的握手，p.address绑定了节点ID时对方必须是这个节点
+func (p *peerConn) handshake() error {
+	address := ""
+	if global.Port != "" {
+		address = global.SelfAddress()
+	}
+	nonce := newNonce()
+	ours := peerHandshake{Magic: peerMagic, Version: peerVersion, Address: address,
+		PubKey: getIdentity().PublicKey(), Nonce: nonce, Encryption: getPeerEncryption()}
+	var ephemeral *ephemeralKey
+	var err error
+	if ours.Encryption != EncryptionOff {
+		if ephemeral, err = newEphemeralKey(); err != nil {
+			return err
+		}
+		ours.Ephemeral = ephemeral.public()
+	}
+	if err := p.writeHandshake(ours); err != nil {
+		return err
+	}
+
+	f, err := readFrame(p.reader, maxHandshake)
+	if err != nil {
+		return err
+	}
+	var hs peerHandshake
+	if f.kind != msgHandshake || gobDecode(f.body, &hs) != nil || hs.Magic != peerMagic {
+		return ErrPeerProtocol
+	}
+	if hs.Version != peerVersion {
+		return fmt.Errorf("%s's peer protocol version is %d, ours is %d", p.address, hs.Version, peerVersion)
+	}
+
+	// 对方的签名正确，并且和以前在这个地址上见到的是同一个节点
+	if !verifyHandshake(hs.PubKey, hs.Signature, "server", nonce, &hs) {
+		return ErrPeerIdentity
+	}
+	p.id = NodeID(hs.PubKey)
+	rememberPeerHost(p.id, p.conn.RemoteAddr())
+	if known := global.GetKnownNodes().ID(p.address); known != "" && known != p.id {
+		return fmt.Errorf("%s is node %s, but was node %s: %w", p.address, p.id, known, ErrPeerIdentity)
+	}
+	if IsBanned(p.id) || IsHostBanned(p.conn.RemoteAddr().String()) {
+		return ErrPeerBanned
+	}
+
+	encrypt, err := negotiateEncryption(ours.Encryption, hs.Encryption)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.address, err)
+	}
+
+	signature, err := signHandshake("client", hs.Nonce, &ours)
+	if err != nil {
+		return err
+	}
+	if err := p.writeHandshake(peerHandshake{Signature: signature}); err != nil {
+		return err
+	}
+	if encrypt {
+		secret, err := ephemeral.shared(hs.Ephemeral)
+		if err != nil {
+			return err
+		}
+		secure, err := newSecureConn(p.reader, p.conn, secret, nonce, hs.Nonce, true)
+		if err != nil {
+			return err
+		}
+		p.in, p.out = secure, secure
+	}
+	log.Debugln("peer", p.address, "is node", p.id, "encrypted:", encrypt)
+	remoteHost, _, _ := net.SplitHostPort(p.conn.RemoteAddr().String())
+	global.LearnAddress(remoteHost, hs.Observed)
+	return nil
+}
+
+func (p *peerConn) writeHandshake(hs peerHandshake) error {
+	body, err := gobEncode(hs)
+	if err != nil {
+		return err
+	}
+	return writeFrame(p.conn, peerFrame{kind: msgHandshake, body: body})
+}
+
+// 读取回复，交给等待的请求
+func (p *peerConn) readLoop() {
+	for {
+		f, err := readFrame(p.in, maxPeerFrame)
+		if err != nil {
+			p.close()
+			return
+		}
+		if f.kind != msgResponse {
+			continue
+		}
+
+		p.mutex.Lock()
+		ch, ok := p.pending[f.id]
+		delete(p.pending, f.id)
+		p.mutex.Unlock()
+		if ok {
+			ch <- f
+		}
+	}
+}
+
+// 关闭连接，等待中的请求都返回ErrPeerClosed
+func (p *peerConn) close() {
+	mutexPeerConns.Lock()
+	if peerConns[p.address] == p {
+		delete(peerConns, p.address)
+	}
+	mutexPeerConns.Unlock()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.conn.Close()
+	for id, ch := range p.pending {
+		close(ch)
+		delete(p.pending, id)
+	}
+}
+
+func (p *peerConn) call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	code, err := peerMethodCode(method)
+	if err != nil {
+		return err
+	}
+	body, err := gobEncode(args)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return ErrPeerClosed
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan peerFrame, 1)
+	p.pending[id] = ch
+	p.mutex.Unlock()
+
+	// 写不出去时连接已经不可用，关闭后下次重新建立
+	p.writeMutex.Lock()
+	deadline, _ := ctx.Deadline()
+	p.conn.SetWriteDeadline(deadline)
+	err = writeFrame(p.out, peerFrame{msgRequest, id, append([]byte{code}, body...)})
+	p.writeMutex.Unlock()
+	if err != nil {
+		p.close()
+		return err
+	}
+
+	var f peerFrame
+	var ok bool
+	select {
+	case f, ok = <-ch:
+		if !ok {
+			return ErrPeerClosed
+		}
+	case <-ctx.Done():
+		// 超时的请求不再等待，之后到达的回复被丢弃
+		p.mutex.Lock()
+		delete(p.pending, id)
+		p.mutex.Unlock()
+		return ctx.Err()
+	}
+	if len(f.body) < 2 {
+		return ErrPeerProtocol
+	}
+	msgLen := int(binary.BigEndian.Uint16(f.body[:2]))
+	if len(f.body) < 2+msgLen {
+		return ErrPeerProtocol
+	}
+	if msgLen != 0 {
+		return &remoteError{string(f.body[2 : 2+msgLen])}
+	}
+	return gobDecode(f.body[2+msgLen:], reply)
+}
+
+// 通过节点间协议调用node的method
+func callPeer(ctx context.Context, node, method string, args interface{}, reply interface{}) error {
+	p, err := getPeerConn(ctx, node)
+	if err != nil {
+		return err
+	}
+	return p.call(ctx, method, args, reply)
+}
+
+// 节点间协议的一个请求，处理函数调用Return结束
+type PeerRequest struct {
+	ID      string // 对方握手时证明的节点ID
+	Address string // 对方握手时告知并且验证过的地址，匿名调用或验证失败时为空
+	body    []byte
+	message string
+	reply   []byte
+}
+
+type PeerHandler func(req *PeerRequest)
+
+var peerHandlers = make(map[string]PeerHandler)
+
+// 注册节点间协议的处理函数，在服务启动前调用
+func HandlePeer(method string, handler PeerHandler) {
+	if _, err := peerMethodCode(method); err != nil {
+		log.Err(err)
+	}
+	peerHandlers[method] = handler
+}
+
+func (req *PeerRequest) Decode(v interface{}) error {
+	return gobDecode(req.body, v)
+}
+
+// 设置回复并结束处理函数，和beego的StopRun一样用panic返回
+func (req *PeerRequest) Return(message string, data interface{}) {
+	req.message = message
+	if message == "" {
+		reply, err := gobEncode(data)
+		if err != nil {
+			req.message = err.Error()
+		}
+		req.reply = reply
+	}
+	panic(errPeerReturn)
+}
+
+func (req *PeerRequest) run(handler PeerHandler) {
+	defer func() {
+		if r := recover(); r != nil && r != errPeerReturn {
+			log.Warnln("peer request panic:", r)
+			req.message = fmt.Sprint(r)
+		}
+	}()
+	handler(req)
+}
+
+func (req *PeerRequest) response() []byte {
+	message := req.message
+	if message == "" && 2+len(req.reply) > maxPeerFrame-5 {
+		message = fmt.Sprintf("Reply of %d bytes is too large", len(req.reply))
+	}
+	if len(message) > 0xffff {
+		message = message[:0xffff]
+	}
+	body := make([]byte, 2, 2+len(message)+len(req.reply))
+	binary.BigEndian.PutUint16(body, uint16(len(message)))
+	body = append(body, message...)
+	if message == "" {
+		body = append(body, req.reply...)
+	}
+	return body
+}
+
+// 一个连接上正在处理的请求个数和字节数
+type peerBudget struct {
+	mutex sync.Mutex
+	cond  *sync.Cond
+	count int
+	bytes int
+}
+
+// 等到有空位时占用n字节，没有正在处理的请求时不受字节数限制
+func (b *peerBudget) acquire(n int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for b.count >= maxPeerInflight || b.count > 0 && b.bytes+n > maxPeerBuffered {
+		b.cond.Wait()
+	}
+	b.count++
+	b.bytes += n
+}
+
+func (b *peerBudget) release(n int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.count--
+	b.bytes -= n
+	b.cond.Signal()
+}
+
+// 处理Upgrade之后的连接，直到连接断开
+func ServePeer(conn net.Conn, reader *bufio.Reader) {
+	defer conn.Close()
+	if IsHostBanned(conn.RemoteAddr().String()) {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(peerDialTimeout))
+	f, err := readFrame(reader, maxHandshake)
+	if err != nil {
+		return
+	}
+	var hs peerHandshake
+	if f.kind != msgHandshake || gobDecode(f.body, &hs) != nil || hs.Magic != peerMagic {
+		log.Warnln("peer handshake failed", conn.RemoteAddr())
+		return
+	}
+	// 版本不同时不签名，直接断开
+	if hs.Version != peerVersion {
+		log.Debugln("peer", conn.RemoteAddr(), "version", hs.Version)
+		return
+	}
+
+	remoteHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	nonce := newNonce()
+	ours := peerHandshake{Magic: peerMagic, Version: peerVersion, Address: global.SelfAddress(),
+		Observed: remoteHost, PubKey: getIdentity().PublicKey(), Nonce: nonce,
+		Encryption: getPeerEncryption()}
+	var ephemeral *ephemeralKey
+	if ours.Encryption != EncryptionOff {
+		if ephemeral, err = newEphemeralKey(); err != nil {
+			return
+		}
+		ours.Ephemeral = ephemeral.public()
+	}
+	if ours.Signature, err = signHandshake("server", hs.Nonce, &ours); err != nil {
+		return
+	}
+	body, err := gobEncode(ours)
+	if err != nil || writeFrame(conn, peerFrame{kind: msgHandshake, body: body}) != nil {
+		return
+	}
+	encrypt, err := negotiateEncryption(ours.Encryption, hs.Encryption)
+	if err != nil {
+		log.Warnln("peer", conn.RemoteAddr(), err)
+		return
+	}
+
+	// 发起方签名了本方的随机数才知道它的节点ID
+	f, err = readFrame(reader, maxHandshake)
+	if err != nil {
+		return
+	}
+	var auth peerHandshake
+	if f.kind != msgHandshake || gobDecode(f.body, &auth) != nil ||
+		!verifyHandshake(hs.PubKey, auth.Signature, "client", nonce, &hs) {
+		log.Warnln("peer authentication failed", conn.RemoteAddr())
+		return
+	}
+	id := NodeID(hs.PubKey)
+	rememberPeerHost(id, conn.RemoteAddr())
+	if IsBanned(id) {
+		return
+	}
+
+	var in io.Reader = reader
+	var out io.Writer = conn
+	if encrypt {
+		secret, err := ephemeral.shared(hs.Ephemeral)
+		if err != nil {
+			return
+		}
+		secure, err := newSecureConn(reader, conn, secret, hs.Nonce, nonce, false)
+		if err != nil {
+			return
+		}
+		in, out = secure, secure
+	}
+
+	// 只相信验证过的地址，回调和广播都用这个地址
+	address := ""
+	if hs.Address != "" {
+		claimed := peerAddress(hs.Address, conn.RemoteAddr())
+		ctx, cancel := context.WithTimeout(ctxRoot, peerDialTimeout)
+		if verifyPeerAddress(ctx, claimed, id, conn.RemoteAddr()) {
+			address = claimed
+		} else {
+			log.Warnf("Peer %s can't prove it is %s\n", id, claimed)
+		}
+		cancel()
+	}
+	conn.SetDeadline(time.Time{})
+
+	// 同时处理的请求数或字节数满了时不再读新的请求
+	var writeMutex sync.Mutex
+	var budget peerBudget
+	budget.cond = sync.NewCond(&budget.mutex)
+	for {
+		f, err := readFrame(in, maxPeerFrame)
+		if err != nil {
+			return
+		}
+		if f.kind != msgRequest || len(f.body) == 0 {
+			continue
+		}
+		// 连接期间被封禁时断开
+		if IsBanned(id) {
+			return
+		}
+
+		budget.acquire(len(f.body))
+		go func(f peerFrame) {
+			defer budget.release(len(f.body))
+			req := &PeerRequest{ID: id, Address: address, body: f.body[1:]}
+			if int(f.body[0]) >= len(peerMethods) {
+				req.message = ErrPeerProtocol.Error()
+			} else if handler, ok := peerHandlers[peerMethods[f.body[0]]]; !ok {
+				req.message = fmt.Sprintf("Method %s is not handled", peerMethods[f.body[0]])
+			} else {
+				req.run(handler)
+			}
+
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			if writeFrame(out, peerFrame{msgResponse, f.id, req.response()}) != nil {
+				conn.Close()
+			}
+		}(f)
+	}
+}
@@ -0,0 +1,197 @@
+package network
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
+	"github.com/astaxie/beego"
+	"golang.org/x/crypto/hkdf"
+)
+
+// 节点间连接的加密：握手时双方各发一个临时的ECDH公钥，和随机数一起被身份密钥签名，
+// 握手结束后用共享密钥派生出两个方向的AES-GCM密钥，之后每次写入是一条加密记录
+//
+//	长度(4字节) 密文
+//
+// 记录的nonce是这个方向上的序号，不在线路上传输
+const (
+	EncryptionOff      byte = iota // 不加密，不能连接要求加密的节点
+	EncryptionOn                   // 双方都支持时加密
+	EncryptionRequired             // 只和加密的节点连接
+)
+
+var encryptionModes = []string{"off", "on", "required"}
+
+var (
+	ErrEncryptionMismatch = errors.New("peer encryption settings don't match")
+
+	oncePeerEncryption sync.Once
+	peerEncryption     byte
+)
+
+const maxSecureRecord = maxPeerFrame + 9 + 16
+
+// --peer-encryption优先，其次是配置文件中的peer_encryption，默认双方都支持时加密
+func getPeerEncryption() byte {
+	oncePeerEncryption.Do(func() {
+		mode := global.PeerEncryption
+		if mode == "" {
+			mode = beego.AppConfig.DefaultString("peer_encryption", "on")
+		}
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		for i, m := range encryptionModes {
+			if m == mode {
+				peerEncryption = byte(i)
+				return
+			}
+		}
+		log.Errln(fmt.Sprintf("Peer encryption %q is not one of %s", mode,
+			strings.Join(encryptionModes, ", ")))
+	})
+	return peerEncryption
+}
+
+// 双方的设置决定是否加密，一方不加密而另一方要求加密时不能连接
+func negotiateEncryption(ours, theirs byte) (bool, error) {
+	if ours == EncryptionOff || theirs == EncryptionOff {
+		if ours == EncryptionRequired || theirs == EncryptionRequired {
+			return false, ErrEncryptionMismatch
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// 临时的ECDH密钥，每个连接一个
+type ephemeralKey struct {
+	sk *ecdsa.PrivateKey
+}
+
+func newEphemeralKey() (*ephemeralKey, error) {
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &ephemeralKey{sk}, nil
+}
+
+func (k *ephemeralKey) public() []byte {
+	return elliptic.Marshal(elliptic.P256(), k.sk.X, k.sk.Y)
+}
+
+// 和对方的临时公钥算出共享密钥
+func (k *ephemeralKey) shared(peer []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), peer)
+	if x == nil {
+		return nil, ErrPeerProtocol
+	}
+	sx, _ := elliptic.P256().ScalarMult(x, y, k.sk.D.Bytes())
+	if sx.Cmp(big.NewInt(0)) == 0 {
+		return nil, ErrPeerProtocol
+	}
+	secret := make([]byte, 32)
+	sx.FillBytes(secret)
+	return secret, nil
+}
+
+// 加密的连接，Write每次写出一条记录，Read从记录中读出明文
+type secureConn struct {
+	in      io.Reader
+	out     io.Writer
+	reader  cipher.AEAD
+	writer  cipher.AEAD
+	readSeq uint64
+	sendSeq uint64
+	buf     []byte
+}
+
+// 用HKDF从共享密钥派生出两个方向的密钥，双方的随机数是盐，方向是info，dialer决定用哪个方向
+func newSecureConn(in io.Reader, out io.Writer, secret, dialerNonce, serverNonce []byte,
+	dialer bool) (*secureConn, error) {
+	salt := append(append([]byte{}, dialerNonce...), serverNonce...)
+	derive := func(label string) (cipher.AEAD, error) {
+		key := make([]byte, 32)
+		kdf := hkdf.New(sha256.New, secret, salt, []byte(PeerUpgrade+"/"+label))
+		if _, err := io.ReadFull(kdf, key); err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+
+	toServer, err := derive("dialer")
+	if err != nil {
+		return nil, err
+	}
+	toDialer, err := derive("server")
+	if err != nil {
+		return nil, err
+	}
+	if dialer {
+		return &secureConn{in: in, out: out, reader: toDialer, writer: toServer}, nil
+	}
+	return &secureConn{in: in, out: out, reader: toServer, writer: toDialer}, nil
+}
+
+func recordNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// 调用者保证不会同时写
+func (s *secureConn) Write(b []byte) (int, error) {
+	record := make([]byte, 4, 4+len(b)+s.writer.Overhead())
+	record = s.writer.Seal(record, recordNonce(s.writer, s.sendSeq), b, nil)
+	s.sendSeq++
+	binary.BigEndian.PutUint32(record[:4], uint32(len(record)-4))
+	if _, err := s.out.Write(record); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// 只在一个协程中读
+func (s *secureConn) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(s.in, header); err != nil {
+			return 0, err
+		}
+		length := binary.BigEndian.Uint32(header)
+		if length < uint32(s.reader.Overhead()) || length > maxSecureRecord {
+			return 0, ErrPeerProtocol
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(s.in, record); err != nil {
+			return 0, err
+		}
+
+		// 解密失败说明被篡改或者顺序不对，连接不能再用
+		plain, err := s.reader.Open(record[:0], recordNonce(s.reader, s.readSeq), record, nil)
+		if err != nil {
+			return 0, ErrPeerProtocol
+		}
+		s.readSeq++
+		s.buf = plain
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
@@ -1,11 +1,18 @@
 package network
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/YouDad/blockchain/global"
+	"github.com/YouDad/blockchain/log"
 	"github.com/astaxie/beego"
 )
 
@@ -15,60 +22,124 @@ var (
 	onceRegister sync.Once
 )
 
+const shutdownTimeout = 3 * time.Second
+
+// 没有--seed，配置文件中也没有seeds时使用的节点
+const defaultSeed = "127.0.0.1:1111"
+
+// 种子节点，ID不为空时只接受这个节点ID
+type seedNode struct {
+	address string
+	id      string
+}
+
+// 种子写成id@host:port时绑定节点ID，地址上换了节点时不能连接
+func parseSeed(seed string) seedNode {
+	i := strings.LastIndex(seed, "@")
+	if i < 0 {
+		return seedNode{address: seed}
+	}
+	id := strings.ToLower(seed[:i])
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 20 {
+		log.Errln(fmt.Sprintf("Seed %s has a malformed node ID", seed))
+	}
+	return seedNode{seed[i+1:], id}
+}
+
+// --seed优先，其次是配置文件中用分号分隔的seeds
+func getSeeds() []seedNode {
+	seeds := global.Seeds
+	if len(seeds) == 0 {
+		seeds = beego.AppConfig.Strings("seeds")
+	}
+
+	var ret []seedNode
+	for _, seed := range seeds {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			ret = append(ret, parseSeed(seed))
+		}
+	}
+	if len(ret) == 0 {
+		ret = []seedNode{{address: defaultSeed}}
+	}
+	return ret
+}
+
 func Register() {
 	onceRegister.Do(func() {
-		global.GetKnownNodes().AddNode("127.0.0.1:1111", 0, 0, global.GroupNum)
+		// 加密设置错误时尽早退出
+		getPeerEncryption()
+		loadKnownNodes()
+		for _, seed := range getSeeds() {
+			global.GetKnownNodes().AddSeed(seed.address, global.GroupNum)
+			if seed.id != "" {
+				global.GetKnownNodes().SetID(seed.address, seed.id)
+			}
+		}
 		UpdateSortedNodes()
 	})
 }
 
-func StartServer(sync func(group int) error) {
+func StartServer(syncGroup func(ctx context.Context, group int) error) {
+	// 节点运行期间独占数据目录，命令行不能同时修改钱包文件
+	log.Err(global.LockDataDir())
+	log.Infof("Node ID %s\n", SelfID())
+
+	var loops sync.WaitGroup
+	loops.Add(2)
+
 	// 周期性维持网络结构
 	go func() {
-		for {
-			time.Sleep(5 * time.Second)
-
+		defer loops.Done()
+		for Sleep(ctxRoot, 5*time.Second) == nil {
 			// 获得节点列表
-			GetKnownNodes()
+			GetKnownNodes(ctxRoot)
 
-			// 并行发送心跳包
+			// 并行发送心跳包，心跳期间节点可能被删除，用同一份地址列表发送和等待
 			knownNodes := global.GetKnownNodes()
-			nodeNumber := len(knownNodes.Get())
+			var addresses []string
+			for address := range knownNodes.Get() {
+				addresses = append(addresses, address)
+			}
 			knownNodes.Release()
-			ready := make(chan interface{}, nodeNumber)
+			ready := make(chan interface{}, len(addresses))
 
-			for nodeAddress := range knownNodes.Get() {
+			for _, nodeAddress := range addresses {
 				go func(address string) {
 					start := time.Now().UnixNano()
-					heartBeat(address)
+					err := heartBeat(ctxRoot, address)
 					end := time.Now().UnixNano()
-					knownNodes.UpdateNode(address, end-start)
+					if err == nil {
+						knownNodes.UpdateNode(address, end-start)
+					} else if ctxRoot.Err() == nil && knownNodes.FailNode(address) {
+						log.Infof("Removed unreachable node %s\n", address)
+					}
 					ready <- 0
 				}(nodeAddress)
 			}
 
-			knownNodes.Release()
-
-			for i := 0; i < nodeNumber; i++ {
+			for range addresses {
 				<-ready
 			}
 			UpdateSortedNodes()
+			saveKnownNodes()
 
-			time.Sleep(10 * time.Second)
+			if Sleep(ctxRoot, 10*time.Second) != nil {
+				return
+			}
 		}
 	}()
 
 	// 周期性同步节点
 	go func() {
-		for {
-			time.Sleep(5 * time.Second)
-
+		defer loops.Done()
+		for Sleep(ctxRoot, 5*time.Second) == nil {
 			// 并行同步
 			ready := make(chan interface{}, global.GroupNum)
 
 			for i := 0; i < global.GroupNum; i++ {
 				go func(group int) {
-					sync(group)
+					syncGroup(ctxRoot, group)
 					ready <- 0
 				}(i + global.GetGroup())
 			}
@@ -77,8 +148,29 @@ func StartServer(sync func(group int) error) {
 				<-ready
 			}
 
-			time.Sleep(10 * time.Second)
+			if Sleep(ctxRoot, 10*time.Second) != nil {
+				return
+			}
+		}
+	}()
+
+	// 收到退出信号时取消进行中的调用，等后台循环结束后退出
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		Shutdown()
+
+		done := make(chan interface{})
+		go func() {
+			loops.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(shutdownTimeout):
 		}
+		os.Exit(0)
 	}()
 
 	// 过半秒设置信号
@@ -87,5 +179,5 @@ func StartServer(sync func(group int) error) {
 		ServerReady <- 0
 	}()
 
-	beego.Run(fmt.Sprintf("0.0.0.0:%s", global.Port))
+	beego.Run(global.ListenAddress())
 }
@@ -1,21 +1,23 @@
 package routers
 
 import (
+	"reflect"
+
 	"github.com/YouDad/blockchain/api"
+	"github.com/YouDad/blockchain/network"
 	"github.com/astaxie/beego"
 )
 
 func init() {
+	// HTTP接口只给本机的命令行用，其他节点通过net/Peer升级到长连接协议，
+	// 这里只保留命令行会调用的节点间方法
 	beego.AddNamespace(beego.NewNamespace("/v1",
 		beego.NSNamespace("/db",
-			beego.NSRouter("/GetGenesis", new(api.DBController), "post:GetGenesis"),
 			beego.NSRouter("/GetBalance", new(api.DBController), "post:GetBalance"),
-			beego.NSRouter("/GetBlocks", new(api.DBController), "post:GetBlocks"),
-			beego.NSRouter("/GossipTxn", new(api.DBController), "post:GossipTxn"),
-			beego.NSRouter("/GossipRelayTxn", new(api.DBController), "post:GossipRelayTxn"),
+			beego.NSRouter("/GetBalances", new(api.DBController), "post:GetBalances"),
+			beego.NSRouter("/FindUsedAddresses", new(api.DBController), "post:FindUsedAddresses"),
 			beego.NSRouter("/GossipBlock", new(api.DBController), "post:GossipBlock"),
-			beego.NSRouter("/GossipBlockHead", new(api.DBController), "post:GossipBlockHead"),
-			beego.NSRouter("/GetHash", new(api.DBController), "post:GetHash"),
+			beego.NSRouter("/SendRawTxn", new(api.DBController), "post:SendRawTxn"),
 		),
 		beego.NSNamespace("/version",
 			beego.NSRouter("/SendVersion", new(api.VersionController), "post:SendVersion"),
@@ -23,9 +25,54 @@ func init() {
 		beego.NSNamespace("/net",
 			beego.NSRouter("/HeartBeat", new(api.NetController), "post:HeartBeat"),
 			beego.NSRouter("/GetKnownNodes", new(api.NetController), "post:GetKnownNodes"),
+			beego.NSRouter("/Peer", new(api.NetController), "post:Peer"),
+			beego.NSRouter("/ListBanned", new(api.NetController), "post:ListBanned"),
+			beego.NSRouter("/Unban", new(api.NetController), "post:Unban"),
+		),
+		beego.NSNamespace("/wallet",
+			beego.NSRouter("/Unlock", new(api.WalletController), "post:Unlock"),
+			beego.NSRouter("/Lock", new(api.WalletController), "post:Lock"),
+			beego.NSRouter("/History", new(api.WalletController), "post:History"),
+			beego.NSRouter("/SetLabel", new(api.WalletController), "post:SetLabel"),
+			beego.NSRouter("/Rescan", new(api.WalletController), "post:Rescan"),
+			beego.NSRouter("/ImportWatchOnly", new(api.WalletController), "post:ImportWatchOnly"),
+			beego.NSRouter("/ExportKey", new(api.WalletController), "post:ExportKey"),
+			beego.NSRouter("/ImportKey", new(api.WalletController), "post:ImportKey"),
+			beego.NSRouter("/SignMessage", new(api.WalletController), "post:SignMessage"),
+			beego.NSRouter("/VerifyMessage", new(api.WalletController), "post:VerifyMessage"),
 		),
 		beego.NSNamespace("/server",
 			beego.NSRouter("/SendCMD", new(api.ServerController), "post:SendCMD"),
+			beego.NSRouter("/CreateRawTxn", new(api.ServerController), "post:CreateRawTxn"),
 		),
 	))
+
+	// 节点之间的调用走长连接协议
+	peerRouter("net/HeartBeat", new(api.NetController), "HeartBeat")
+	peerRouter("net/GetKnownNodes", new(api.NetController), "GetKnownNodes")
+	peerRouter("version/SendVersion", new(api.VersionController), "SendVersion")
+	peerRouter("db/GetGenesis", new(api.DBController), "GetGenesis")
+	peerRouter("db/GetBalance", new(api.DBController), "GetBalance")
+	peerRouter("db/GetBalances", new(api.DBController), "GetBalances")
+	peerRouter("db/FindUsedAddresses", new(api.DBController), "FindUsedAddresses")
+	peerRouter("db/GetBlocks", new(api.DBController), "GetBlocks")
+	peerRouter("db/GossipTxn", new(api.DBController), "GossipTxn")
+	peerRouter("db/SendRawTxn", new(api.DBController), "SendRawTxn")
+	peerRouter("db/GossipRelayTxn", new(api.DBController), "GossipRelayTxn")
+	peerRouter("db/GossipBlock", new(api.DBController), "GossipBlock")
+	peerRouter("db/GossipBlockHead", new(api.DBController), "GossipBlockHead")
+	peerRouter("db/GetHash", new(api.DBController), "GetHash")
+	peerRouter("db/Inv", new(api.DBController), "Inv")
+	peerRouter("db/GetData", new(api.DBController), "GetData")
+	peerRouter("db/GetHeaders", new(api.DBController), "GetHeaders")
+}
+
+// 和beego一样，每个请求新建一个控制器，按名字调用方法
+func peerRouter(method string, controller api.PeerController, name string) {
+	t := reflect.TypeOf(controller).Elem()
+	network.HandlePeer(method, func(req *network.PeerRequest) {
+		c := reflect.New(t)
+		c.Interface().(api.PeerController).SetPeerRequest(req)
+		c.MethodByName(name).Call(nil)
+	})
 }
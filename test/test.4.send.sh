@@ -13,7 +13,7 @@ AddressB="${TestRegMatch}"
 
 RunTest create_blockchain "${VPortA} --address ${AddressA}"
 
-RunTest send "${VPortA} --amount 50000000 --from ${AddressA} --to ${AddressB} --mine"
+RunTest send "${VPortA} --amount 50 --from ${AddressA} --to ${AddressB} --mine"
 
 RunTest get_version "${VPortA} --address ${AddressA}"
 RunTest get_balance "-v3 ${VPortA} --address ${AddressB}"
@@ -27,6 +27,6 @@ RunTest all "${VPortB} --address ${AddressB}" &
 sleep 1
 
 RunTest get_version "${VPortB} --address ${AddressB}"
-RunTest send "${VPortB} --amount 1 --from ${AddressB} --to ${AddressA}"
+RunTest send "${VPortB} --amount 0.000001 --from ${AddressB} --to ${AddressA}"
 sleep 1
 killall blockchain
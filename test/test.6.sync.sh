@@ -13,7 +13,7 @@ AddressB="${TestRegMatch}"
 
 RunTest create_blockchain "${VPortA} --address ${AddressA}"
 
-RunTest send "${VPortA} --amount 50000000 --from ${AddressA} --to ${AddressB} --mine"
+RunTest send "${VPortA} --amount 50 --from ${AddressA} --to ${AddressB} --mine"
 
 RunTest get_version "${VPortA} --address ${AddressA}"
 RunTest get_balance "-v3 ${VPortA} --address ${AddressB}"
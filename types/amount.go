@@ -0,0 +1,110 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 金额，以最小单位计数，1个币等于Coin个最小单位
+type Amount int64
+
+const (
+	AmountDecimals        = 6
+	Coin           Amount = 1_000_000
+	MaxMoney       Amount = 21_000_000 * Coin
+)
+
+var (
+	ErrAmountOverflow = errors.New("amount overflow")
+	ErrAmountRange    = errors.New("amount out of range")
+	ErrAmountFormat   = errors.New("amount format error")
+)
+
+// 金额是否在[0, MaxMoney]内
+func (a Amount) Valid() bool {
+	return 0 <= a && a <= MaxMoney
+}
+
+// 带溢出和范围检查的加法
+func (a Amount) Add(b Amount) (Amount, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, ErrAmountOverflow
+	}
+	sum := a + b
+	if !sum.Valid() {
+		return 0, ErrAmountRange
+	}
+	return sum, nil
+}
+
+// 带溢出和范围检查的减法
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b == math.MinInt64 {
+		return 0, ErrAmountOverflow
+	}
+	return a.Add(-b)
+}
+
+// 以固定小数位数格式化，如 50.000000
+func (a Amount) String() string {
+	sign := ""
+	value := uint64(a)
+	if a < 0 {
+		sign = "-"
+		value = uint64(-(a + 1)) + 1
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign,
+		value/uint64(Coin), AmountDecimals, value%uint64(Coin))
+}
+
+// 作为命令行参数时和--amount一样以币为单位
+func (a *Amount) Set(str string) error {
+	amount, err := ParseAmount(str)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func (a *Amount) Type() string {
+	return "amount"
+}
+
+// 解析带小数的金额，小数位数不能超过AmountDecimals
+func ParseAmount(str string) (Amount, error) {
+	parts := strings.Split(str, ".")
+	if len(parts) > 2 || parts[0] == "" {
+		return 0, ErrAmountFormat
+	}
+
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > AmountDecimals {
+		return 0, ErrAmountFormat
+	}
+	fraction += strings.Repeat("0", AmountDecimals-len(fraction))
+
+	whole, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, ErrAmountFormat
+	}
+	frac, err := strconv.ParseUint(fraction, 10, 64)
+	if err != nil {
+		return 0, ErrAmountFormat
+	}
+
+	if whole > uint64(MaxMoney/Coin) {
+		return 0, ErrAmountRange
+	}
+	amount := Amount(whole)*Coin + Amount(frac)
+	if !amount.Valid() {
+		return 0, ErrAmountRange
+	}
+	return amount, nil
+}
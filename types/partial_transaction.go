@@ -0,0 +1,134 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 一个输入上收集到的签名
+type PartialSig struct {
+	PubKey    PublicKey
+	Signature Signature
+}
+
+// 多方签名用的部分签名交易，在签名者之间以文件传递
+type PartialTxn struct {
+	Txn        Transaction       // 未签名的交易
+	PrevOuts   []TxnOutput       // 每个输入引用的输出
+	Signatures []PartialSig      // 每个输入收集到的签名
+	Metadata   map[string]string // 附加信息，如用途、创建者
+}
+
+var (
+	ErrPartialTxnMismatch   = errors.New("partial transactions are not the same transaction")
+	ErrPartialTxnIncomplete = errors.New("partial transaction is not completely signed")
+)
+
+func NewPartialTxn(raw RawTxn) *PartialTxn {
+	return &PartialTxn{
+		Txn:        raw.Txn.TrimmedCopy(),
+		PrevOuts:   raw.PrevOuts,
+		Signatures: make([]PartialSig, len(raw.Txn.Vin)),
+		Metadata:   make(map[string]string),
+	}
+}
+
+// 检查结构是否完整，从文件读入后调用
+func (p *PartialTxn) Check() error {
+	if len(p.PrevOuts) != len(p.Txn.Vin) || len(p.Signatures) != len(p.Txn.Vin) {
+		return errors.New(fmt.Sprintf("Partial transaction has %d inputs, "+
+			"%d previous outputs and %d signatures",
+			len(p.Txn.Vin), len(p.PrevOuts), len(p.Signatures)))
+	}
+	if p.Metadata == nil {
+		p.Metadata = make(map[string]string)
+	}
+	return nil
+}
+
+// 第inIndex个输入带上签名后的签名是否有效
+func (p *PartialTxn) verifySig(inIndex int, sig PartialSig) bool {
+	if !p.PrevOuts[inIndex].IsLockedWithKey(sig.PubKey) {
+		return false
+	}
+
+	txn := p.Txn.TrimmedCopy()
+	txn.Vin[inIndex].PubKey = sig.PubKey
+	txn.Vin[inIndex].Signature = sig.Signature
+	return txn.VerifyInput(inIndex, p.PrevOuts[inIndex], nil)
+}
+
+// 对所有引用signer公钥所属输出的输入签名，返回签名的输入数
+func (p *PartialTxn) Sign(signer Signer) (int, error) {
+	pubKey := signer.PublicKey()
+	signed := 0
+	for inIndex, prevOut := range p.PrevOuts {
+		if !prevOut.IsLockedWithKey(pubKey) {
+			continue
+		}
+
+		txn := p.Txn.TrimmedCopy()
+		err := txn.SignInput(inIndex, signer, p.PrevOuts)
+		if err != nil {
+			return signed, err
+		}
+
+		p.Signatures[inIndex] = PartialSig{pubKey, txn.Vin[inIndex].Signature}
+		signed++
+	}
+	return signed, nil
+}
+
+// 合并其他签名者的签名，只接受有效的签名
+func (p *PartialTxn) Combine(other *PartialTxn) error {
+	if !p.Txn.Hash().Equal(other.Txn.Hash()) {
+		return ErrPartialTxnMismatch
+	}
+
+	for inIndex, sig := range other.Signatures {
+		if len(sig.Signature) == 0 || len(p.Signatures[inIndex].Signature) != 0 {
+			continue
+		}
+
+		if !p.verifySig(inIndex, sig) {
+			return errors.New(fmt.Sprintf("Invalid signature of input %d", inIndex))
+		}
+		p.Signatures[inIndex] = sig
+	}
+
+	for key, value := range other.Metadata {
+		if _, ok := p.Metadata[key]; !ok {
+			p.Metadata[key] = value
+		}
+	}
+	return nil
+}
+
+// 已签名的输入数
+func (p *PartialTxn) SignedCount() int {
+	count := 0
+	for _, sig := range p.Signatures {
+		if len(sig.Signature) != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// 所有输入都签名后，生成可以广播的交易
+func (p *PartialTxn) Finalize() (*RawTxn, error) {
+	if p.SignedCount() != len(p.Txn.Vin) {
+		return nil, ErrPartialTxnIncomplete
+	}
+
+	txn := p.Txn.TrimmedCopy()
+	for inIndex, sig := range p.Signatures {
+		if !p.verifySig(inIndex, sig) {
+			return nil, errors.New(fmt.Sprintf("Invalid signature of input %d", inIndex))
+		}
+		txn.Vin[inIndex].PubKey = sig.PubKey
+		txn.Vin[inIndex].Signature = sig.Signature
+	}
+
+	return &RawTxn{Txn: txn, PrevOuts: p.PrevOuts}, nil
+}
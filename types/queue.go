@@ -48,7 +48,11 @@ func (q *Queue) Pop() interface{} {
 	if q.array == nil {
 		panic(ErrInit)
 	}
+	if q.front == q.back {
+		return nil
+	}
 	ret := q.array[q.front]
+	q.array[q.front] = nil
 	q.front = (q.front + 1) % q.capability
 	return ret
 }
@@ -57,7 +61,7 @@ func (q *Queue) Len() int {
 	if q.array == nil {
 		panic(ErrInit)
 	}
-	return len(q.array)
+	return (q.back - q.front + q.capability) % q.capability
 }
 
 func (q *Queue) Get() []interface{} {
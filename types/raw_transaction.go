@@ -0,0 +1,76 @@
+package types
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/YouDad/blockchain/utils"
+)
+
+// 离线签名用的交易，附带每个输入引用的输出，签名时不需要访问区块链
+type RawTxn struct {
+	Txn      Transaction
+	PrevOuts []TxnOutput
+}
+
+var (
+	ErrRawTxnFormat = errors.New("raw transaction format error")
+)
+
+// 编码成十六进制字符串，便于在命令行和文件之间传递
+func (raw RawTxn) Encode() string {
+	return hex.EncodeToString(utils.Encode(raw))
+}
+
+func (raw RawTxn) String() string {
+	return string(utils.Encode(raw))
+}
+
+func DecodeRawTxn(str string) (*RawTxn, error) {
+	bytes, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, ErrRawTxnFormat
+	}
+
+	var raw RawTxn
+	err = utils.Decode(bytes, &raw)
+	if err != nil {
+		return nil, ErrRawTxnFormat
+	}
+
+	if len(raw.PrevOuts) != len(raw.Txn.Vin) {
+		return nil, errors.New(fmt.Sprintf("%s: %d inputs but %d previous outputs",
+			ErrRawTxnFormat, len(raw.Txn.Vin), len(raw.PrevOuts)))
+	}
+	return &raw, nil
+}
+
+// 对所有引用signer公钥所属输出的输入签名，返回签名的输入数
+func (raw *RawTxn) Sign(signer Signer) (int, error) {
+	pubKey := signer.PublicKey()
+	signed := 0
+	for inIndex, prevOut := range raw.PrevOuts {
+		if !prevOut.IsLockedWithKey(pubKey) {
+			continue
+		}
+
+		raw.Txn.Vin[inIndex].PubKey = pubKey
+		err := raw.Txn.SignInput(inIndex, signer, raw.PrevOuts)
+		if err != nil {
+			return signed, err
+		}
+		signed++
+	}
+	return signed, nil
+}
+
+// 是否所有输入都已签名
+func (raw RawTxn) IsComplete() bool {
+	for _, vin := range raw.Txn.Vin {
+		if len(vin.Signature) == 0 || len(vin.PubKey) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sync"
+)
+
+// 签名验证缓存，记录已经验证通过的(签名数据哈希, 公钥, 签名)
+// 交易进入交易池时验证过的签名，在区块到达时不用再验证一遍
+type SigCache struct {
+	entries map[[32]byte]bool
+	ring    [][32]byte
+	next    int
+	mutex   sync.RWMutex
+}
+
+var DefaultSigCache = NewSigCache(100_000)
+
+func NewSigCache(size int) *SigCache {
+	return &SigCache{
+		entries: make(map[[32]byte]bool, size),
+		ring:    make([][32]byte, size),
+	}
+}
+
+func sigCacheKey(sigHash HashValue, pubKey PublicKey, sig Signature) [32]byte {
+	return sha256.Sum256(bytes.Join([][]byte{sigHash, pubKey, sig}, []byte{0}))
+}
+
+func (c *SigCache) Exists(sigHash HashValue, pubKey PublicKey, sig Signature) bool {
+	if c == nil {
+		return false
+	}
+
+	key := sigCacheKey(sigHash, pubKey, sig)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.entries[key]
+}
+
+func (c *SigCache) Add(sigHash HashValue, pubKey PublicKey, sig Signature) {
+	if c == nil || len(c.ring) == 0 {
+		return
+	}
+
+	key := sigCacheKey(sigHash, pubKey, sig)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entries[key] {
+		return
+	}
+
+	// 容量满时淘汰最早加入的记录
+	delete(c.entries, c.ring[c.next])
+	c.ring[c.next] = key
+	c.entries[key] = true
+	c.next = (c.next + 1) % len(c.ring)
+}
+
+func (c *SigCache) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.entries)
+}
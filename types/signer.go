@@ -0,0 +1,51 @@
+package types
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+)
+
+// 签名者，私钥可以在本进程内，也可以在外部的签名进程中
+type Signer interface {
+	PublicKey() PublicKey
+	// 对数据做ECDSA签名，返回定长编码的r和s
+	Sign(data []byte) (Signature, error)
+}
+
+// 签名者自己从交易计算签名的数据，签名前可以检查交易的输出和金额，
+// 外部签名进程不对任意数据签名
+type TxnSigner interface {
+	Signer
+	// 对txn的第inIndex个输入签名，prevOuts是所有输入引用的输出
+	SignTxnInput(txn Transaction, inIndex int, prevOuts []TxnOutput) (Signature, error)
+}
+
+// 用内存中的私钥签名
+type KeySigner struct {
+	sk PrivateKey
+}
+
+func NewKeySigner(sk PrivateKey) *KeySigner {
+	return &KeySigner{sk}
+}
+
+// 公钥的X和Y定长编码
+func (s *KeySigner) PublicKey() PublicKey {
+	pubKey := make(PublicKey, 64)
+	s.sk.PublicKey.X.FillBytes(pubKey[:32])
+	s.sk.PublicKey.Y.FillBytes(pubKey[32:])
+	return pubKey
+}
+
+func (s *KeySigner) Sign(data []byte) (Signature, error) {
+	r, ss, err := ecdsa.Sign(rand.Reader, &s.sk, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// r和s定长编码，验证时从中间切开
+	signature := make(Signature, 64)
+	r.FillBytes(signature[:32])
+	ss.FillBytes(signature[32:])
+	return signature, nil
+}
@@ -3,8 +3,8 @@ package types
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -29,6 +29,68 @@ func (txn Transaction) IsCoinbase() bool {
 	return len(txn.Vin) == 1 && txn.Vin[0].VoutIndex == -1
 }
 
+var (
+	ErrInputValue        = errors.New("input value doesn't match the spent output")
+	ErrInsufficientInput = errors.New("outputs exceed inputs")
+)
+
+// 检查交易中的金额都在[0, MaxMoney]内，且求和不溢出
+func (txn Transaction) CheckValues() error {
+	var sumIn, sumOut Amount
+	var err error
+	for _, vin := range txn.Vin {
+		if !vin.VoutValue.Valid() {
+			return ErrAmountRange
+		}
+		if sumIn, err = sumIn.Add(vin.VoutValue); err != nil {
+			return err
+		}
+	}
+
+	for _, vout := range txn.Vout {
+		if !vout.Value.Valid() {
+			return ErrAmountRange
+		}
+		if sumOut, err = sumOut.Add(vout.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 检查输入记录的金额和引用的输出一致，输出的总额不超过引用的输出的总额，
+// prevOuts[i]是第i个输入引用的输出，为nil时输入在其他组，由转发的默克尔路径保证，不检查总额
+func (txn Transaction) CheckInputs(prevOuts []*TxnOutput) error {
+	var sumIn, sumOut Amount
+	var err error
+	known := true
+	for i, vin := range txn.Vin {
+		if prevOuts[i] == nil {
+			known = false
+			continue
+		}
+		if vin.VoutValue != prevOuts[i].Value {
+			return ErrInputValue
+		}
+		if sumIn, err = sumIn.Add(prevOuts[i].Value); err != nil {
+			return err
+		}
+	}
+	if !known {
+		return nil
+	}
+
+	for _, vout := range txn.Vout {
+		if sumOut, err = sumOut.Add(vout.Value); err != nil {
+			return err
+		}
+	}
+	if sumOut > sumIn {
+		return ErrInsufficientInput
+	}
+	return nil
+}
+
 func (txn Transaction) TrimmedCopy() Transaction {
 	var inputs []TxnInput
 	var outputs []TxnOutput
@@ -58,30 +120,51 @@ func (txn Transaction) TrimmedCopy() Transaction {
 	return txCopy
 }
 
-func (txn *Transaction) Sign(sk PrivateKey, hashedTxn map[string]Transaction) error {
+func (txn *Transaction) Sign(signer Signer, hashedTxn map[string]Transaction) error {
 	if txn.IsCoinbase() {
 		return nil
 	}
 
-	txnCopy := txn.TrimmedCopy()
-
-	for inIndex, vin := range txnCopy.Vin {
+	prevOuts := make([]TxnOutput, len(txn.Vin))
+	for inIndex, vin := range txn.Vin {
 		prevTxn := hashedTxn[vin.VoutHash.String()]
-		if len(prevTxn.Vout) <= vin.VoutIndex {
-			log.Errln(txn, hashedTxn)
+		if vin.VoutIndex < 0 || len(prevTxn.Vout) <= vin.VoutIndex {
+			return errors.New(fmt.Sprintf("Transaction is not found, %s", vin.VoutHash))
 		}
-		txnCopy.Vin[inIndex].PubKey = PublicKey(prevTxn.Vout[vin.VoutIndex].PubKeyHash)
-		dataToSign := []byte(fmt.Sprintf("%s\n", txnCopy))
+		prevOuts[inIndex] = prevTxn.Vout[vin.VoutIndex]
+	}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &sk, dataToSign)
+	for inIndex := range txn.Vin {
+		err := txn.SignInput(inIndex, signer, prevOuts)
 		if err != nil {
 			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+	}
+	return nil
+}
+
+// 第inIndex个输入签名的数据，prevOut是该输入引用的输出
+func (txn Transaction) SigData(inIndex int, prevOut TxnOutput) []byte {
+	txnCopy := txn.TrimmedCopy()
+	txnCopy.Vin[inIndex].PubKey = PublicKey(prevOut.PubKeyHash)
+	return []byte(fmt.Sprintf("%s\n", txnCopy))
+}
 
-		txn.Vin[inIndex].Signature = signature
-		txnCopy.Vin[inIndex].PubKey = nil
+// 对第inIndex个输入签名，prevOuts是所有输入引用的输出，
+// TxnSigner拿到整个交易自己计算签名的数据
+func (txn *Transaction) SignInput(inIndex int, signer Signer, prevOuts []TxnOutput) error {
+	var signature Signature
+	var err error
+	if txnSigner, ok := signer.(TxnSigner); ok {
+		signature, err = txnSigner.SignTxnInput(*txn, inIndex, prevOuts)
+	} else {
+		signature, err = signer.Sign(txn.SigData(inIndex, prevOuts[inIndex]))
 	}
+	if err != nil {
+		return err
+	}
+
+	txn.Vin[inIndex].Signature = signature
 	return nil
 }
 
@@ -91,37 +174,56 @@ func (txn Transaction) Verify(hashedTxn map[string]Transaction) bool {
 		return true
 	}
 
-	txnCopy := txn.TrimmedCopy()
-	curve := elliptic.P256()
-
 	// 遍历交易的输入
 	for inIndex, vin := range txn.Vin {
 		prevTxn := hashedTxn[vin.VoutHash.String()]
-		txnCopy.Vin[inIndex].PubKey = PublicKey(prevTxn.Vout[vin.VoutIndex].PubKeyHash)
-		dataToVerify := []byte(fmt.Sprintf("%s\n", txnCopy))
-
-		// 验证TxnInput的签名是否正确
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, dataToVerify, &r, &s) {
+		if vin.VoutIndex < 0 || len(prevTxn.Vout) <= vin.VoutIndex {
+			log.Debugln("Verify prevTxn not found", txn, hashedTxn)
+			return false
+		}
+
+		if !txn.VerifyInput(inIndex, prevTxn.Vout[vin.VoutIndex], DefaultSigCache) {
 			log.Debugln("ecdsa.Verify Failed", txn, hashedTxn)
 			return false
 		}
-		txnCopy.Vin[inIndex].PubKey = nil
 	}
 
 	return true
 }
 
+// 验证第inIndex个输入的签名，prevOut是该输入引用的输出，验证通过的签名记入cache
+func (txn Transaction) VerifyInput(inIndex int, prevOut TxnOutput, cache *SigCache) bool {
+	vin := txn.Vin[inIndex]
+	if len(vin.Signature) == 0 || len(vin.PubKey) == 0 {
+		return false
+	}
+
+	dataToVerify := txn.SigData(inIndex, prevOut)
+	sigHash := sha256.Sum256(dataToVerify)
+	if cache.Exists(sigHash[:], vin.PubKey, vin.Signature) {
+		return true
+	}
+
+	// 验证TxnInput的签名是否正确
+	r := big.Int{}
+	s := big.Int{}
+	sigLen := len(vin.Signature)
+	r.SetBytes(vin.Signature[:(sigLen / 2)])
+	s.SetBytes(vin.Signature[(sigLen / 2):])
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(vin.PubKey)
+	x.SetBytes(vin.PubKey[:(keyLen / 2)])
+	y.SetBytes(vin.PubKey[(keyLen / 2):])
+	rawPubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
+	if !ecdsa.Verify(&rawPubKey, dataToVerify, &r, &s) {
+		return false
+	}
+
+	cache.Add(sigHash[:], vin.PubKey, vin.Signature)
+	return true
+}
+
 func (txn *Transaction) RelayVerify(merkleRoot HashValue, relayMerklePath []MerklePath) bool {
 	hash := txn.Hash()
 	var sha [32]byte
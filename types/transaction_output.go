@@ -5,7 +5,7 @@ import (
 )
 
 type TxnOutput struct {
-	Value      int64
+	Value      Amount
 	PubKeyHash HashValue
 }
 
@@ -0,0 +1,52 @@
+package types
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 一个待验证签名的交易输入
+type InputJob struct {
+	Txn     *Transaction
+	Index   int
+	PrevOut TxnOutput
+}
+
+// 用workers个协程并行验证所有输入的签名，有一个失败就返回false
+func VerifyInputs(jobs []InputJob, workers int, cache *SigCache) bool {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	var failed int32
+	ch := make(chan InputJob)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				if atomic.LoadInt32(&failed) != 0 {
+					continue
+				}
+				if !job.Txn.VerifyInput(job.Index, job.PrevOut, cache) {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		if atomic.LoadInt32(&failed) != 0 {
+			break
+		}
+		ch <- job
+	}
+	close(ch)
+	wg.Wait()
+
+	return failed == 0
+}
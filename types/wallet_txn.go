@@ -0,0 +1,15 @@
+package types
+
+// 钱包中一个地址在一笔交易中的收支记录
+type WalletTxn struct {
+	Hash          HashValue
+	Address       string
+	Amount        Amount // 收入为正，支出为负
+	Coinbase      bool
+	Group         int
+	Height        int32
+	Timestamp     int64
+	Counterparty  []string
+	Confirmations int32  // 查询时填写
+	Label         string // 查询时填写
+}
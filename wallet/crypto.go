@@ -0,0 +1,72 @@
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	cryptoKeyLen = 32
+	saltLen      = 16
+)
+
+var (
+	ErrWalletLocked       = errors.New("wallet is locked")
+	ErrWalletEncrypted    = errors.New("wallet is already encrypted")
+	ErrWalletNotEncrypted = errors.New("wallet is not encrypted")
+	ErrPassphrase         = errors.New("passphrase is incorrect")
+)
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// 用scrypt从口令派生对称密钥
+func deriveKey(passphrase string, salt []byte, n int) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, n, scryptR, scryptP, cryptoKeyLen)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AES-GCM加密，返回随机nonce和密文
+func seal(key, plaintext []byte) (nonce, ciphertext []byte, err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce, err = randomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, nil, err
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+// AES-GCM解密，口令错误时认证失败
+func open(key, nonce, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,319 @@
+package wallet
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/YouDad/blockchain/global"
+)
+
+// 派生路径 m/44'/hdCoinType'/0'/change'/index'，找零地址的change为1，
+// P-256只支持私钥派生，全部使用硬化索引
+const (
+	hdHardened = uint32(1 << 31)
+	hdPurpose  = 44
+	hdCoinType = 1
+	hdGapLimit = 20
+)
+
+var (
+	ErrNoHDSeed     = errors.New("wallet has no HD seed")
+	ErrHDSeedExists = errors.New("wallet already has an HD seed")
+	ErrHDGroup      = errors.New("no HD address can be derived for this group")
+)
+
+// SLIP-10中的扩展私钥
+type hdKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// 钱包的HD状态，钱包锁定时seed为nil
+type hdWallet struct {
+	hasSeed bool
+	seed    []byte
+}
+
+var hdState hdWallet
+
+func hmacSHA512(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// SLIP-10 NIST P-256 主密钥
+func newMasterKey(seed []byte) hdKey {
+	n := elliptic.P256().Params().N
+	I := hmacSHA512([]byte("Nist256p1 seed"), seed)
+	for {
+		k := new(big.Int).SetBytes(I[:32])
+		if k.Sign() != 0 && k.Cmp(n) < 0 {
+			return hdKey{I[:32], I[32:]}
+		}
+		I = hmacSHA512([]byte("Nist256p1 seed"), I)
+	}
+}
+
+// SLIP-10 硬化子密钥
+func (k hdKey) child(index uint32) hdKey {
+	n := elliptic.P256().Params().N
+	index |= hdHardened
+	ser := make([]byte, 4)
+	binary.BigEndian.PutUint32(ser, index)
+
+	I := hmacSHA512(k.chainCode, []byte{0}, k.key, ser)
+	for {
+		IL := new(big.Int).SetBytes(I[:32])
+		childKey := new(big.Int).Add(IL, new(big.Int).SetBytes(k.key))
+		childKey.Mod(childKey, n)
+		if IL.Cmp(n) < 0 && childKey.Sign() != 0 {
+			key := make([]byte, 32)
+			childKey.FillBytes(key)
+			return hdKey{key, I[32:]}
+		}
+		I = hmacSHA512(k.chainCode, []byte{1}, I[32:], ser)
+	}
+}
+
+func (k hdKey) derive(path ...uint32) hdKey {
+	for _, index := range path {
+		k = k.child(index)
+	}
+	return k
+}
+
+func hdChange(internal bool) uint32 {
+	if internal {
+		return 1
+	}
+	return 0
+}
+
+// 从种子派生第index个地址的钱包，internal为真时派生找零地址
+func deriveWallet(seed []byte, internal bool, index uint32) *Wallet {
+	k := newMasterKey(seed).derive(hdPurpose, hdCoinType, 0, hdChange(internal), index)
+	sk := privateKeyFromBytes(k.key)
+	return &Wallet{
+		PrivateKey: sk,
+		PublicKey:  publicKeyBytes(sk.PublicKey),
+		HD:         true,
+		HDIndex:    index,
+		Internal:   internal,
+	}
+}
+
+// 钱包是否有HD种子
+func HasSeed() bool {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	return hdState.hasSeed
+}
+
+// 设置HD种子，已有种子时返回ErrHDSeedExists
+func (ws Wallets) SetSeed(seed []byte) error {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if hdState.hasSeed {
+		return ErrHDSeedExists
+	}
+	if lockState.encrypted && lockState.key == nil {
+		return ErrWalletLocked
+	}
+
+	hdState = hdWallet{hasSeed: true, seed: seed}
+	return nil
+}
+
+func (ws Wallets) hdSeed() ([]byte, error) {
+	if !hdState.hasSeed {
+		return nil, ErrNoHDSeed
+	}
+	if hdState.seed == nil {
+		return nil, ErrWalletLocked
+	}
+	return hdState.seed, nil
+}
+
+// 地址所在的组
+func (w Wallet) Group() int {
+	return global.GetGroupByPubKeyHash(w.pubKeyHash())
+}
+
+// 已派生的HD地址中最大的索引加一，group为-1时不区分组
+func (ws Wallets) nextHDIndex(internal bool, group int) uint32 {
+	next := uint32(0)
+	for _, w := range ws {
+		if w.HD && w.Internal == internal && w.HDIndex >= next &&
+			(group == -1 || w.Group() == group) {
+			next = w.HDIndex + 1
+		}
+	}
+	return next
+}
+
+// 公钥哈希的首字节决定组，组数超过256时后面的组无法派生
+func reachableGroupNum() int {
+	if global.MaxGroupNum > 256 {
+		return 256
+	}
+	return global.MaxGroupNum
+}
+
+// 派生下一个HD钱包，调用者负责把它加入Wallets
+func (ws Wallets) NewHDWallet() (*Wallet, error) {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	seed, err := ws.hdSeed()
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveWallet(seed, false, ws.nextHDIndex(false, -1)), nil
+}
+
+// 派生指定组的下一个HD钱包，从该组已用的最大索引往后找第一个落在该组的索引，
+// 同一个种子在任何机器上都会得到相同的地址序列
+func (ws Wallets) NewHDWalletInGroup(group int) (*Wallet, error) {
+	return ws.newHDWalletInGroup(false, group)
+}
+
+func (ws Wallets) newHDWalletInGroup(internal bool, group int) (*Wallet, error) {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	return ws.deriveInGroup(internal, group)
+}
+
+// 调用者持有lockState.mutex
+func (ws Wallets) deriveInGroup(internal bool, group int) (*Wallet, error) {
+	if group < 0 || group >= reachableGroupNum() {
+		return nil, ErrHDGroup
+	}
+	seed, err := ws.hdSeed()
+	if err != nil {
+		return nil, err
+	}
+
+	for index := ws.nextHDIndex(internal, group); index < hdHardened; index++ {
+		w := deriveWallet(seed, internal, index)
+		if w.Group() == group {
+			return w, nil
+		}
+	}
+	return nil, ErrHDGroup
+}
+
+// 按gap limit重新扫描收款和找零两条路径的HD地址，isUsed返回每个地址是否在链上出现过，
+// 每个组都连续gapLimit个地址未使用时停止，返回找到的已使用地址数
+func (ws Wallets) RescanHD(gapLimit int, isUsed func(addresses []string) ([]bool, error)) (int, error) {
+	if gapLimit <= 0 {
+		gapLimit = hdGapLimit
+	}
+
+	lockState.mutex.Lock()
+	seed, err := ws.hdSeed()
+	lockState.mutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	found := 0
+	for _, internal := range []bool{false, true} {
+		n, err := ws.rescanHD(seed, internal, gapLimit, isUsed)
+		found += n
+		if err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+func (ws Wallets) rescanHD(seed []byte, internal bool, gapLimit int,
+	isUsed func(addresses []string) ([]bool, error)) (int, error) {
+
+	groupNum := reachableGroupNum()
+	unused := make([]int, groupNum) // 每个组连续未使用的地址数
+	done := func() bool {
+		for _, n := range unused {
+			if n < gapLimit {
+				return false
+			}
+		}
+		return true
+	}
+
+	found := 0
+	batchSize := gapLimit * groupNum
+	for start := uint32(0); !done(); start += uint32(batchSize) {
+		batch := make([]*Wallet, batchSize)
+		addresses := make([]string, batchSize)
+		for i := range batch {
+			batch[i] = deriveWallet(seed, internal, start+uint32(i))
+			addresses[i] = batch[i].String()
+		}
+
+		used, err := isUsed(addresses)
+		if err != nil {
+			return found, err
+		}
+
+		lockState.mutex.Lock()
+		for i, w := range batch {
+			if i < len(used) && used[i] {
+				ws[addresses[i]] = w
+				unused[w.Group()] = 0
+				found++
+			} else {
+				unused[w.Group()]++
+			}
+		}
+		lockState.mutex.Unlock()
+	}
+	return found, nil
+}
+
+// 派生路径的字符串形式
+func (w Wallet) HDPath() string {
+	if !w.HD {
+		return ""
+	}
+	return fmt.Sprintf("m/%d'/%d'/0'/%d'/%d'", hdPurpose, hdCoinType, hdChange(w.Internal), w.HDIndex)
+}
+
+// 生成和group同组的新找零地址，有HD种子时从找零路径派生，否则随机生成，
+// 派生和加入Wallets在同一次加锁内完成并立即保存，并发发送不会拿到同一个找零地址
+func (ws Wallets) ReserveChangeWallet(group int) (*Wallet, error) {
+	if group < 0 || group >= reachableGroupNum() {
+		return nil, ErrHDGroup
+	}
+
+	lockState.mutex.Lock()
+	var w *Wallet
+	var err error
+	if hdState.hasSeed {
+		w, err = ws.deriveInGroup(true, group)
+	} else if lockState.encrypted && lockState.key == nil {
+		err = ErrWalletLocked
+	} else {
+		w = NewWallet()
+		for w.Group() != group {
+			w = NewWallet()
+		}
+		w.Internal = true
+	}
+	if err == nil {
+		ws[w.String()] = w
+	}
+	lockState.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return w, ws.SaveToFile()
+}
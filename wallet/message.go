@@ -0,0 +1,85 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/YouDad/blockchain/types"
+)
+
+// 签名消息前加上前缀，避免签出的内容被当成交易
+const messagePrefix = "Blockchain Signed Message:\n"
+
+var ErrMessageSignature = errors.New("message signature is invalid")
+
+// 前缀、消息长度和消息拼接后做两次SHA256
+func messageDigest(message string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(messagePrefix)
+	length := make([]byte, binary.MaxVarintLen64)
+	buf.Write(length[:binary.PutUvarint(length, uint64(len(message)))])
+	buf.WriteString(message)
+
+	first := sha256.Sum256(buf.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// 自己计算消息摘要的签名者，例如外部签名进程
+type messageSigner interface {
+	SignMessage(message string) (types.Signature, error)
+}
+
+// 用签名者签名消息，P-256不能从签名恢复公钥，签名中带上公钥，
+// 结果为Base64编码的公钥、r和s，各自定长
+func SignMessage(signer types.Signer, message string) (string, error) {
+	var rs types.Signature
+	var err error
+	if ms, ok := signer.(messageSigner); ok {
+		rs, err = ms.SignMessage(message)
+	} else {
+		rs, err = signer.Sign(messageDigest(message))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	signature := append(append([]byte{}, signer.PublicKey()...), rs...)
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// 验证消息是address的私钥签的
+func VerifyMessage(address, signature, message string) error {
+	if !ValidateAddress(address) {
+		return fmt.Errorf("Address %s is not valid", address)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != 128 {
+		return ErrMessageSignature
+	}
+
+	pubKey := types.PublicKey(sig[:64])
+	if !HashPubKey(pubKey).Equal(AddressToPubKeyHash(address)) {
+		return ErrMessageSignature
+	}
+
+	pk := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(pubKey[:32]),
+		Y:     new(big.Int).SetBytes(pubKey[32:]),
+	}
+	r := new(big.Int).SetBytes(sig[64:96])
+	s := new(big.Int).SetBytes(sig[96:])
+	if !ecdsa.Verify(&pk, messageDigest(message), r, s) {
+		return ErrMessageSignature
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	mnemonicEntropyLen = 16 // 128位熵，对应12个单词
+	mnemonicIterations = 2048
+	mnemonicSeedLen    = 64
+)
+
+var ErrMnemonic = errors.New("mnemonic is invalid")
+
+// 生成新的BIP39助记词
+func NewMnemonic() (string, error) {
+	entropy, err := randomBytes(mnemonicEntropyLen)
+	if err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+// 熵后面接上sha256的前len(entropy)/4位作为校验，每11位对应一个单词
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := uint(len(entropy) / 4)
+	hash := sha256.Sum256(entropy)
+
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, checksumBits)
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	wordNum := (len(entropy)*8 + int(checksumBits)) / 11
+	words := make([]string, wordNum)
+	mask := big.NewInt(2047)
+	for i := wordNum - 1; i >= 0; i-- {
+		index := new(big.Int).And(data, mask)
+		words[i] = wordlist[index.Int64()]
+		data.Rsh(data, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// 检查助记词的单词和校验位
+func ValidateMnemonic(mnemonic string) error {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return ErrMnemonic
+	}
+
+	data := new(big.Int)
+	for _, word := range words {
+		index := wordIndex(word)
+		if index < 0 {
+			return ErrMnemonic
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := uint(len(words) / 3)
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1))
+	data.Rsh(data, checksumBits)
+
+	entropy := make([]byte, len(words)*4/3)
+	data.FillBytes(entropy)
+	hash := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(hash[0]>>(8-checksumBits)) {
+		return ErrMnemonic
+	}
+	return nil
+}
+
+// 单词表有序，二分查找
+func wordIndex(word string) int {
+	l, r := 0, len(wordlist)
+	for l < r {
+		mid := (l + r) / 2
+		if wordlist[mid] < word {
+			l = mid + 1
+		} else {
+			r = mid
+		}
+	}
+	if l < len(wordlist) && wordlist[l] == word {
+		return l
+	}
+	return -1
+}
+
+// 用PBKDF2从助记词生成种子
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(mnemonic), []byte("mnemonic"+passphrase),
+		mnemonicIterations, mnemonicSeedLen, sha512.New)
+}
@@ -0,0 +1,380 @@
+package wallet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
+)
+
+// 外部签名协议：每行一个JSON请求，签名进程按顺序每行回复一个JSON
+//
+//	{"Method":"PublicKey","Address":"1..."} -> {"PublicKey":"hex"}
+//	{"Method":"SignTxn","Address":"1...","Txn":{...},"InIndex":0,"PrevOuts":[...]} -> {"Signature":"hex"}
+//	{"Method":"SignMessage","Address":"1...","Message":"..."} -> {"Signature":"hex"}
+//
+// 签名进程自己计算签名的数据，检查输入金额并显示交易的输出和手续费，不对任意数据签名，
+// 出错时回复{"Error":"..."}
+type SignerRequest struct {
+	Method   string
+	Address  string
+	Txn      *types.Transaction `json:",omitempty"`
+	InIndex  int                `json:",omitempty"`
+	PrevOuts []types.TxnOutput  `json:",omitempty"`
+	Message  string             `json:",omitempty"`
+}
+
+type SignerResponse struct {
+	PublicKey types.PublicKey `json:",omitempty"`
+	Signature types.Signature `json:",omitempty"`
+	Error     string          `json:",omitempty"`
+}
+
+const (
+	SignerMethodPublicKey   = "PublicKey"
+	SignerMethodSignTxn     = "SignTxn"
+	SignerMethodSignMessage = "SignMessage"
+)
+
+var (
+	ErrNoSigner      = errors.New("no external signer is configured")
+	ErrSignerRawData = errors.New("external signer only signs transactions and messages")
+)
+
+// 外部签名进程的连接，第一次使用时建立，请求串行发送
+type externalSigner struct {
+	target string
+	args   []string // exec:的命令行
+	conn   io.ReadWriter
+	reader *bufio.Reader
+	cmd    *exec.Cmd
+	mutex  sync.Mutex
+}
+
+var extSigner *externalSigner
+
+// 设置外部签名进程，target为unix:<socket路径>或exec:<命令行>，
+// 命令行中可以用引号和反斜杠包含空格，设置后只读地址通过外部签名进程签名，target为空时取消
+func SetExternalSigner(target string) error {
+	if target == "" {
+		extSigner = nil
+		return nil
+	}
+	s := &externalSigner{target: target}
+	if command := strings.TrimPrefix(target, "exec:"); command != target {
+		args, err := splitCommand(command)
+		if err != nil {
+			return fmt.Errorf("Signer %s: %v", target, err)
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("Signer %s has no command", target)
+		}
+		s.args = args
+	} else if !strings.HasPrefix(target, "unix:") {
+		return fmt.Errorf("Signer %s should start with unix: or exec:", target)
+	}
+	extSigner = s
+	return nil
+}
+
+// 使用已经建立的连接作为外部签名进程
+func SetExternalSignerConn(conn io.ReadWriter) {
+	extSigner = &externalSigner{target: "conn:", conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func HasExternalSigner() bool {
+	return extSigner != nil
+}
+
+// 按shell的规则拆分命令行，支持单引号、双引号和反斜杠转义
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// 标准输入输出连接到签名进程，关闭时关闭签名进程的标准输入
+type processConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func (s *externalSigner) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+
+	if path := strings.TrimPrefix(s.target, "unix:"); path != s.target {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	} else if s.args != nil {
+		cmd := exec.Command(s.args[0], s.args[1:]...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		s.conn = processConn{stdout, stdin}
+		s.cmd = cmd
+	} else {
+		return ErrNoSigner
+	}
+	s.reader = bufio.NewReader(s.conn)
+	return nil
+}
+
+// 连接出错后关闭，签名进程被结束并回收，下次调用时重新建立
+func (s *externalSigner) reset() {
+	if closer, ok := s.conn.(io.Closer); ok {
+		closer.Close()
+	}
+	if s.cmd != nil {
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+		s.cmd = nil
+	}
+	s.conn = nil
+}
+
+func (s *externalSigner) call(req SignerRequest) (*SignerResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.conn.Write(append(data, '\n')); err != nil {
+		s.reset()
+		return nil, err
+	}
+
+	line, err := s.reader.ReadBytes('\n')
+	if err != nil {
+		s.reset()
+		return nil, err
+	}
+
+	var resp SignerResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// 由外部签名进程保管私钥的地址
+type remoteSigner struct {
+	address string
+	pubKey  types.PublicKey
+}
+
+func (s *remoteSigner) PublicKey() types.PublicKey {
+	return s.pubKey
+}
+
+func (s *remoteSigner) Sign(data []byte) (types.Signature, error) {
+	return nil, ErrSignerRawData
+}
+
+func (s *remoteSigner) SignTxnInput(txn types.Transaction, inIndex int, prevOuts []types.TxnOutput) (types.Signature, error) {
+	return s.call(SignerRequest{Method: SignerMethodSignTxn, Address: s.address,
+		Txn: &txn, InIndex: inIndex, PrevOuts: prevOuts})
+}
+
+func (s *remoteSigner) SignMessage(message string) (types.Signature, error) {
+	return s.call(SignerRequest{Method: SignerMethodSignMessage, Address: s.address, Message: message})
+}
+
+func (s *remoteSigner) call(req SignerRequest) (types.Signature, error) {
+	resp, err := extSigner.call(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Signature) != 64 {
+		return nil, fmt.Errorf("Signer returned a signature of length %d", len(resp.Signature))
+	}
+	return resp.Signature, nil
+}
+
+// 取出地址的签名者，只读地址在没有外部签名进程时返回ErrWatchOnly
+func (ws Wallets) GetSigner(address string) (types.Signer, error) {
+	w, ok := ws.GetWallet(address)
+	if !ok || !w.WatchOnly {
+		sk, err := ws.GetPrivateKey(address)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewKeySigner(sk), nil
+	}
+
+	if extSigner == nil {
+		return nil, ErrWatchOnly
+	}
+
+	// 只导入地址时向签名进程要公钥
+	pubKey := w.PublicKey
+	if len(pubKey) == 0 {
+		resp, err := extSigner.call(SignerRequest{Method: SignerMethodPublicKey, Address: address})
+		if err != nil {
+			return nil, err
+		}
+		pubKey = resp.PublicKey
+	}
+	if HashPubKey(pubKey).String() != AddressToPubKeyHash(address).String() {
+		return nil, fmt.Errorf("Signer's public key doesn't match address %s", address)
+	}
+	return &remoteSigner{address, pubKey}, nil
+}
+
+// 按协议处理签名请求，直到r读完，签名进程用它对外提供服务
+func ServeSigner(ws Wallets, r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var resp SignerResponse
+		var req SignerRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			resp.Error = err.Error()
+		} else if signer, err := ws.GetSigner(req.Address); err != nil {
+			resp.Error = err.Error()
+		} else if req.Method == SignerMethodPublicKey {
+			resp.PublicKey = signer.PublicKey()
+		} else if req.Method == SignerMethodSignTxn {
+			resp.Signature, err = ws.signTxnInput(signer, req)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+		} else if req.Method == SignerMethodSignMessage {
+			resp.Signature, err = signer.Sign(messageDigest(req.Message))
+			if err != nil {
+				resp.Error = err.Error()
+			}
+		} else {
+			resp.Error = fmt.Sprintf("Unknown method %s", req.Method)
+		}
+
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+}
+
+// 检查请求签名的交易，输入金额必须和引用的输出一致，签名前显示输出和手续费
+func (ws Wallets) signTxnInput(signer types.Signer, req SignerRequest) (types.Signature, error) {
+	txn := req.Txn
+	if txn == nil || req.InIndex < 0 || req.InIndex >= len(txn.Vin) || len(req.PrevOuts) != len(txn.Vin) {
+		return nil, errors.New("Transaction, input index or previous outputs are missing")
+	}
+	if !req.PrevOuts[req.InIndex].IsLockedWithKey(signer.PublicKey()) {
+		return nil, fmt.Errorf("Input %d doesn't spend %s", req.InIndex, req.Address)
+	}
+	if err := txn.CheckValues(); err != nil {
+		return nil, err
+	}
+
+	// 输入金额包含在签名的数据中，和引用的输出不一致的交易不会被接受，手续费可信
+	prevOuts := make([]*types.TxnOutput, len(req.PrevOuts))
+	var sumIn, sumOut types.Amount
+	for i := range req.PrevOuts {
+		prevOuts[i] = &req.PrevOuts[i]
+		sumIn += req.PrevOuts[i].Value
+	}
+	if err := txn.CheckInputs(prevOuts); err != nil {
+		return nil, err
+	}
+	for i, out := range txn.Vout {
+		address := PubKeyHashToAddress(out.PubKeyHash)
+		if _, ok := ws.GetWallet(address); ok {
+			address += " (own)"
+		}
+		log.Infof("Signing %s input %d, output %d: %s to %s\n", txn.Hash(), req.InIndex, i, out.Value, address)
+		sumOut += out.Value
+	}
+	log.Infof("Signing %s input %d, fee: %s\n", txn.Hash(), req.InIndex, sumIn-sumOut)
+
+	signed := *txn
+	signed.Vin = append([]types.TxnInput{}, txn.Vin...)
+	if err := signed.SignInput(req.InIndex, signer, req.PrevOuts); err != nil {
+		return nil, err
+	}
+	return signed.Vin[req.InIndex].Signature, nil
+}
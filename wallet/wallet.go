@@ -6,7 +6,9 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/YouDad/blockchain/log"
 	"github.com/YouDad/blockchain/types"
@@ -17,29 +19,68 @@ import (
 const version = byte(0x00)
 const addressChecksumLen = 4
 
+// 导出私钥时的版本前缀，和地址区分开
+const privateKeyVersion = byte(0x80)
+
 // Wallet stores private and public keys
 type Wallet struct {
 	PrivateKey types.PrivateKey
 	PublicKey  types.PublicKey
+	HD         bool            // 是否由HD种子派生
+	HDIndex    uint32          // HD派生索引
+	WatchOnly  bool            // 只有地址或公钥，没有私钥
+	PubKeyHash types.HashValue // 只导入地址时没有公钥，保存公钥哈希
+	Internal   bool            // 找零地址，不用于收款
 }
 
+var (
+	ErrWatchOnly     = errors.New("address is watch-only")
+	ErrAddressExists = errors.New("address is already in the wallet")
+	ErrKeyEncoding   = errors.New("private key encoding is invalid")
+)
+
 // NewWallet creates and returns a Wallet
 func NewWallet() *Wallet {
 	private, public := newKeyPair()
-	return &Wallet{private, public}
+	return &Wallet{PrivateKey: private, PublicKey: public}
 }
 
-// GetAddress returns wallet address
-func (w Wallet) GetAddress() []byte {
-	pubKeyHash := HashPubKey(w.PublicKey)
+// NewWalletFromPrivateKey creates a Wallet from an existing private key
+func NewWalletFromPrivateKey(sk types.PrivateKey) *Wallet {
+	return &Wallet{PrivateKey: sk, PublicKey: publicKeyBytes(sk.PublicKey)}
+}
 
-	versionedPayload := append([]byte{version}, pubKeyHash...)
-	checksum := checksum(versionedPayload)
+// NewWatchOnlyWallet creates a Wallet without private key from address or public key
+func NewWatchOnlyWallet(address string, pubKey types.PublicKey) (*Wallet, error) {
+	w := &Wallet{WatchOnly: true}
+	if len(pubKey) != 0 {
+		if len(pubKey) != 64 {
+			return nil, fmt.Errorf("Public key length %d is not 64", len(pubKey))
+		}
+		w.PublicKey = pubKey
+		if address != "" && address != w.String() {
+			return nil, fmt.Errorf("Public key doesn't match address %s", address)
+		}
+		return w, nil
+	}
 
-	fullPayload := append(versionedPayload, checksum...)
-	address := utils.Base58Encode(fullPayload)
+	if !ValidateAddress(address) {
+		return nil, fmt.Errorf("Address %s is not valid", address)
+	}
+	w.PubKeyHash = AddressToPubKeyHash(address)
+	return w, nil
+}
+
+func (w Wallet) pubKeyHash() types.HashValue {
+	if len(w.PublicKey) == 0 {
+		return w.PubKeyHash
+	}
+	return HashPubKey(w.PublicKey)
+}
 
-	return address
+// GetAddress returns wallet address
+func (w Wallet) GetAddress() []byte {
+	return []byte(PubKeyHashToAddress(w.pubKeyHash()))
 }
 
 func (w Wallet) String() string {
@@ -74,6 +115,25 @@ func ValidateAddress(address string) bool {
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 }
 
+// AddressToPubKeyHash returns the public key hash encoded in address,
+// or nil when address is too short to contain one
+func AddressToPubKeyHash(address string) types.HashValue {
+	pubKeyHash := utils.Base58Decode([]byte(address))
+	if len(pubKeyHash) <= 1+addressChecksumLen {
+		return nil
+	}
+	return pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
+}
+
+// PubKeyHashToAddress encodes public key hash as address
+func PubKeyHashToAddress(pubKeyHash types.HashValue) string {
+	versionedPayload := append([]byte{version}, pubKeyHash...)
+	checksum := checksum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+	return string(utils.Base58Encode(fullPayload))
+}
+
 // Checksum generates a checksum for a public key
 func checksum(payload []byte) []byte {
 	firstSHA := sha256.Sum256(payload)
@@ -87,6 +147,56 @@ func newKeyPair() (types.PrivateKey, types.PublicKey) {
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	log.Err(err)
 
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-	return *private, pubKey
+	return *private, publicKeyBytes(private.PublicKey)
+}
+
+// 公钥的X和Y定长编码，验证签名时从中间切开
+func publicKeyBytes(pk ecdsa.PublicKey) types.PublicKey {
+	pubKey := make(types.PublicKey, 64)
+	pk.X.FillBytes(pubKey[:32])
+	pk.Y.FillBytes(pubKey[32:])
+	return pubKey
+}
+
+// 从私钥的D恢复出完整的私钥
+func privateKeyFromBytes(d []byte) types.PrivateKey {
+	curve := elliptic.P256()
+	sk := types.PrivateKey{D: new(big.Int).SetBytes(d)}
+	sk.PublicKey.Curve = curve
+	sk.PublicKey.X, sk.PublicKey.Y = curve.ScalarBaseMult(d)
+	return sk
+}
+
+// 私钥的D定长编码
+func privateKeyToBytes(sk types.PrivateKey) []byte {
+	d := make([]byte, 32)
+	sk.D.FillBytes(d)
+	return d
+}
+
+// 私钥编码为带校验和的Base58文本
+func EncodePrivateKey(sk types.PrivateKey) string {
+	payload := append([]byte{privateKeyVersion}, privateKeyToBytes(sk)...)
+	return string(utils.Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// 解析EncodePrivateKey的结果，校验和错误时返回ErrKeyEncoding
+func DecodePrivateKey(s string) (types.PrivateKey, error) {
+	payload := utils.Base58Decode([]byte(s))
+	if len(payload) != 1+32+addressChecksumLen || payload[0] != privateKeyVersion {
+		return types.PrivateKey{}, ErrKeyEncoding
+	}
+
+	actualChecksum := payload[len(payload)-addressChecksumLen:]
+	payload = payload[:len(payload)-addressChecksumLen]
+	if !bytes.Equal(actualChecksum, checksum(payload)) {
+		return types.PrivateKey{}, ErrKeyEncoding
+	}
+
+	d := payload[1:]
+	n := new(big.Int).SetBytes(d)
+	if n.Sign() == 0 || n.Cmp(elliptic.P256().Params().N) >= 0 {
+		return types.PrivateKey{}, ErrKeyEncoding
+	}
+	return privateKeyFromBytes(d), nil
 }
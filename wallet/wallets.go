@@ -8,9 +8,10 @@ import (
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/YouDad/blockchain/global"
-	"github.com/YouDad/blockchain/log"
+	"github.com/YouDad/blockchain/types"
 )
 
 type Wallets map[string]*Wallet
@@ -25,9 +26,61 @@ func init() {
 	gob.Register(elliptic.P256())
 }
 
+// 钱包文件中的一个密钥
+type walletKey struct {
+	PrivateKey []byte // 加密时为空
+	PublicKey  types.PublicKey
+	HD         bool
+	HDIndex    uint32
+	WatchOnly  bool
+	PubKeyHash types.HashValue
+	Internal   bool
+}
+
+// 钱包文件，加密时私钥保存在Secrets中
+type walletFile struct {
+	Version   int
+	Keys      map[string]walletKey
+	Encrypted bool
+	ScryptN   int
+	Salt      []byte
+	Nonce     []byte
+	Secrets   []byte
+	HasSeed   bool
+	Seed      []byte // 加密时为空
+	Labels    map[string]string
+}
+
+// 加密保存的部分
+type walletSecrets struct {
+	PrivateKeys map[string][]byte
+	Seed        []byte
+}
+
+// 钱包的加密状态
+type walletLock struct {
+	encrypted bool
+	scryptN   int
+	salt      []byte
+	nonce     []byte
+	secrets   []byte
+	key       []byte // 解锁时的对称密钥，锁定时为nil
+	timer     *time.Timer
+	mutex     sync.Mutex
+}
+
+var lockState walletLock
+
+// 地址或交易哈希的标签
+var labels = make(map[string]string)
+
+func walletFilename() string {
+	return global.DataPath(fmt.Sprintf("wallet%s.dat", global.Port))
+}
+
 func getWallets() (Wallets, error) {
 	wallets := make(Wallets)
-	filename := fmt.Sprintf("wallet%s.dat", global.Port)
+	filename := walletFilename()
 
 	_, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -39,11 +92,33 @@ func getWallets() (Wallets, error) {
 		return wallets, err
 	}
 
-	reader := bytes.NewReader(fileContent)
-	decoder := gob.NewDecoder(reader)
-	decoder.Decode(&wallets)
+	var file walletFile
+	err = gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&file)
+	if err != nil || file.Version == 0 {
+		// 旧版本的钱包文件直接保存Wallets
+		err = gob.NewDecoder(bytes.NewReader(fileContent)).Decode(&wallets)
+		return wallets, err
+	}
+
+	for address, key := range file.Keys {
+		w := &Wallet{PublicKey: key.PublicKey, HD: key.HD, HDIndex: key.HDIndex,
+			WatchOnly: key.WatchOnly, PubKeyHash: key.PubKeyHash, Internal: key.Internal}
+		if !file.Encrypted && !key.WatchOnly {
+			w.PrivateKey = privateKeyFromBytes(key.PrivateKey)
+		}
+		wallets[address] = w
+	}
 
-	return wallets, err
+	lockState.encrypted = file.Encrypted
+	lockState.scryptN = file.ScryptN
+	lockState.salt = file.Salt
+	lockState.nonce = file.Nonce
+	lockState.secrets = file.Secrets
+	hdState = hdWallet{hasSeed: file.HasSeed, seed: file.Seed}
+	if file.Labels != nil {
+		labels = file.Labels
+	}
+	return wallets, nil
 }
 
 func GetWallets() (Wallets, error) {
@@ -53,12 +128,321 @@ func GetWallets() (Wallets, error) {
 	return instanceWallets, errWallets
 }
 
-func (ws Wallets) SaveToFile() {
-	var content bytes.Buffer
+// 丢弃内存中的钱包，重新读取当前数据目录中的钱包文件
+func ReloadWallets() (Wallets, error) {
+	onceWallets.Do(func() {})
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if lockState.timer != nil {
+		lockState.timer.Stop()
+		lockState.timer = nil
+	}
+	lockState.encrypted = false
+	lockState.scryptN = 0
+	lockState.salt, lockState.nonce, lockState.secrets, lockState.key = nil, nil, nil, nil
+	hdState = hdWallet{}
+	labels = make(map[string]string)
+
+	instanceWallets, errWallets = getWallets()
+	return instanceWallets, errWallets
+}
+
+// 钱包是否加密
+func IsEncrypted() bool {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	return lockState.encrypted
+}
+
+// 钱包是否加密且未解锁
+func IsLocked() bool {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	return lockState.encrypted && lockState.key == nil
+}
+
+// 取出地址对应的私钥，钱包锁定时返回ErrWalletLocked
+func (ws Wallets) GetPrivateKey(address string) (types.PrivateKey, error) {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	w, ok := ws[address]
+	if !ok {
+		return types.PrivateKey{}, fmt.Errorf("You haven't %s's PrivateKey", address)
+	}
+	if w.WatchOnly {
+		return types.PrivateKey{}, ErrWatchOnly
+	}
+
+	if lockState.encrypted && lockState.key == nil {
+		return types.PrivateKey{}, ErrWalletLocked
+	}
+	return w.PrivateKey, nil
+}
+
+// 用口令加密钱包，加密后钱包处于解锁状态
+func (ws Wallets) Encrypt(passphrase string) error {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if lockState.encrypted {
+		return ErrWalletEncrypted
+	}
+
+	salt, err := randomBytes(saltLen)
+	if err != nil {
+		return err
+	}
+
+	key, err := deriveKey(passphrase, salt, scryptN)
+	if err != nil {
+		return err
+	}
+
+	lockState.encrypted = true
+	lockState.scryptN = scryptN
+	lockState.salt = salt
+	lockState.key = key
+	return nil
+}
+
+// 用口令解锁钱包，timeout后自动锁定，timeout为0时不自动锁定
+func (ws Wallets) Unlock(passphrase string, timeout time.Duration) error {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if !lockState.encrypted {
+		return ErrWalletNotEncrypted
+	}
+
+	key, err := deriveKey(passphrase, lockState.salt, lockState.scryptN)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := open(key, lockState.nonce, lockState.secrets)
+	if err != nil {
+		return err
+	}
 
-	encoder := gob.NewEncoder(&content)
-	log.Err(encoder.Encode(ws))
+	var secrets walletSecrets
+	err = gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&secrets)
+	if err != nil {
+		return err
+	}
 
-	walletFile := fmt.Sprintf("wallet%s.dat", global.Port)
-	log.Err(ioutil.WriteFile(walletFile, content.Bytes(), 0644))
+	for address, d := range secrets.PrivateKeys {
+		if w, ok := ws[address]; ok {
+			w.PrivateKey = privateKeyFromBytes(d)
+		}
+	}
+	if hdState.hasSeed {
+		hdState.seed = secrets.Seed
+	}
+	lockState.key = key
+
+	if lockState.timer != nil {
+		lockState.timer.Stop()
+		lockState.timer = nil
+	}
+	if timeout > 0 {
+		lockState.timer = time.AfterFunc(timeout, ws.Lock)
+	}
+	return nil
+}
+
+// 从内存中清除私钥
+func (ws Wallets) Lock() {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if !lockState.encrypted {
+		return
+	}
+
+	for _, w := range ws {
+		w.PrivateKey = types.PrivateKey{}
+	}
+	hdState.seed = nil
+	lockState.key = nil
+	if lockState.timer != nil {
+		lockState.timer.Stop()
+		lockState.timer = nil
+	}
+}
+
+// 更换口令，旧口令正确时用新口令重新加密，钱包保持原来的锁定状态
+func (ws Wallets) ChangePassphrase(oldPassphrase, newPassphrase string) error {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if !lockState.encrypted {
+		return ErrWalletNotEncrypted
+	}
+
+	oldKey, err := deriveKey(oldPassphrase, lockState.salt, lockState.scryptN)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := open(oldKey, lockState.nonce, lockState.secrets)
+	if err != nil {
+		return err
+	}
+
+	salt, err := randomBytes(saltLen)
+	if err != nil {
+		return err
+	}
+
+	key, err := deriveKey(newPassphrase, salt, scryptN)
+	if err != nil {
+		return err
+	}
+
+	nonce, secrets, err := seal(key, plaintext)
+	if err != nil {
+		return err
+	}
+
+	lockState.scryptN = scryptN
+	lockState.salt = salt
+	lockState.nonce = nonce
+	lockState.secrets = secrets
+	if lockState.key != nil {
+		lockState.key = key
+	}
+	return nil
+}
+
+// 导入只读地址
+func (ws Wallets) ImportWatchOnly(w *Wallet) error {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	address := w.String()
+	if _, ok := ws[address]; ok {
+		return ErrAddressExists
+	}
+	ws[address] = w
+	return nil
+}
+
+// 导入私钥，已有同一地址的只读记录时补上私钥
+func (ws Wallets) ImportKey(w *Wallet) error {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if lockState.encrypted && lockState.key == nil {
+		return ErrWalletLocked
+	}
+
+	address := w.String()
+	if old, ok := ws[address]; ok && !old.WatchOnly {
+		return ErrAddressExists
+	}
+	ws[address] = w
+	return nil
+}
+
+// 取出地址对应的钱包记录
+func (ws Wallets) GetWallet(address string) (*Wallet, bool) {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	w, ok := ws[address]
+	return w, ok
+}
+
+// 加入新生成的钱包，例如导入的地址
+func (ws Wallets) AddWallet(w *Wallet) {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	ws[w.String()] = w
+}
+
+// 给地址或交易哈希设置标签，label为空时删除
+func (ws Wallets) SetLabel(key, label string) {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	if label == "" {
+		delete(labels, key)
+	} else {
+		labels[key] = label
+	}
+}
+
+func (ws Wallets) GetLabel(key string) string {
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+	return labels[key]
+}
+
+// 只有锁定了数据目录的进程能写钱包文件，命令行和运行中的节点不会互相覆盖
+func (ws Wallets) SaveToFile() error {
+	if err := global.LockDataDir(); err != nil {
+		return err
+	}
+
+	lockState.mutex.Lock()
+	defer lockState.mutex.Unlock()
+
+	file := walletFile{
+		Version:   1,
+		Keys:      make(map[string]walletKey),
+		Encrypted: lockState.encrypted,
+		ScryptN:   lockState.scryptN,
+		Salt:      lockState.salt,
+		Nonce:     lockState.nonce,
+		Secrets:   lockState.secrets,
+		HasSeed:   hdState.hasSeed,
+		Labels:    labels,
+	}
+
+	secrets := walletSecrets{PrivateKeys: make(map[string][]byte)}
+	if lockState.encrypted {
+		secrets.Seed = hdState.seed
+	} else {
+		file.Seed = hdState.seed
+	}
+	for address, w := range ws {
+		key := walletKey{PublicKey: w.PublicKey, HD: w.HD, HDIndex: w.HDIndex,
+			WatchOnly: w.WatchOnly, PubKeyHash: w.PubKeyHash, Internal: w.Internal}
+		if w.PrivateKey.D != nil {
+			if lockState.encrypted {
+				secrets.PrivateKeys[address] = privateKeyToBytes(w.PrivateKey)
+			} else {
+				key.PrivateKey = privateKeyToBytes(w.PrivateKey)
+			}
+		}
+		file.Keys[address] = key
+	}
+
+	if lockState.encrypted {
+		// 锁定时没有密钥，不能保存新的私钥
+		if lockState.key == nil {
+			if len(secrets.PrivateKeys) != 0 {
+				return ErrWalletLocked
+			}
+		} else {
+			var content bytes.Buffer
+			err := gob.NewEncoder(&content).Encode(secrets)
+			if err != nil {
+				return err
+			}
+
+			file.Nonce, file.Secrets, err = seal(lockState.key, content.Bytes())
+			if err != nil {
+				return err
+			}
+			lockState.nonce = file.Nonce
+			lockState.secrets = file.Secrets
+		}
+	}
+
+	var content bytes.Buffer
+	err := gob.NewEncoder(&content).Encode(file)
+	if err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，避免写到一半时损坏钱包
+	walletFile := walletFilename()
+	err = ioutil.WriteFile(walletFile+".tmp", content.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(walletFile+".tmp", walletFile)
 }